@@ -0,0 +1,132 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+// BatchMutate applies a sequence of mutations, each of whose OldRoot must be
+// the root after the previous one. Disabled mutations leave the root
+// unchanged.
+type BatchMutate struct {
+	Mutations []MutateWithVerify
+	KeyBits   int
+}
+
+func (p BatchMutate) NewRoot(api frontend.API) frontend.Variable {
+	if len(p.Mutations) == 0 {
+		panic("empty batch")
+	}
+	root := p.Mutations[0].OldRoot
+	for i, m := range p.Mutations {
+		if i > 0 {
+			api.AssertIsEqual(m.OldRoot, root)
+		}
+		api.AssertIsBoolean(m.Enabled)
+		m.KeyBits = p.KeyBits
+		root = m.NewRoot(api)
+	}
+	return root
+}
+
+type BatchMutateCircuit struct {
+	OldRoot   frontend.Variable `gnark:",public"`
+	NewRoot   frontend.Variable `gnark:",public"`
+	Mutations []MutateWithVerify
+	KeyBits   int
+}
+
+func NewBatchMutateCircuit(n, levels int) *BatchMutateCircuit {
+	c := &BatchMutateCircuit{Mutations: make([]MutateWithVerify, n)}
+	for i := range c.Mutations {
+		c.Mutations[i] = emptyMutateWithVerify(levels)
+	}
+	return c
+}
+
+// NewBatchMutateAssignment returns the assignment of a batch of n mutations
+// from the proofs of consecutive mutations, padded with disabled mutations.
+func NewBatchMutateAssignment(proofs []native.MutateProof, n, levels int) (*BatchMutateCircuit, error) {
+	b, err := NewBatchMutate(proofs, n, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchMutateCircuit{
+		OldRoot:   proofs[0].OldRoot(),
+		NewRoot:   proofs[len(proofs)-1].NewRoot(),
+		Mutations: b.Mutations,
+	}, nil
+}
+
+func NewBatchMutate(proofs []native.MutateProof, n, levels int) (BatchMutate, error) {
+	if len(proofs) == 0 {
+		return BatchMutate{}, errors.New("empty batch")
+	}
+	if len(proofs) > n {
+		return BatchMutate{}, fmt.Errorf("batch has %d mutations, circuit has %d", len(proofs), n)
+	}
+	b := BatchMutate{Mutations: make([]MutateWithVerify, n)}
+	for i, p := range proofs {
+		if i > 0 && p.OldRoot().Cmp(proofs[i-1].NewRoot()) != 0 {
+			return BatchMutate{}, fmt.Errorf("mutation %d does not follow the previous mutation", i)
+		}
+		m, err := NewMutateWithVerify(p, levels)
+		if err != nil {
+			return BatchMutate{}, err
+		}
+		b.Mutations[i] = m
+	}
+	root := proofs[len(proofs)-1].NewRoot()
+	for i := len(proofs); i < n; i++ {
+		b.Mutations[i] = disabledMutateWithVerify(root, levels)
+	}
+	return b, nil
+}
+
+func emptyMutateWithVerify(levels int) MutateWithVerify {
+	return MutateWithVerify{
+		Mutate: Mutate{
+			Siblings:    make([]frontend.Variable, levels),
+			LowSiblings: make([]frontend.Variable, levels),
+		},
+		OldSiblings: make([]frontend.Variable, levels),
+	}
+}
+
+// disabledMutateWithVerify returns a mutation that leaves root unchanged.
+func disabledMutateWithVerify(root frontend.Variable, levels int) MutateWithVerify {
+	zeros := func() []frontend.Variable {
+		v := make([]frontend.Variable, levels)
+		for i := range v {
+			v[i] = 0
+		}
+		return v
+	}
+	return MutateWithVerify{
+		Mutate: Mutate{
+			Enabled:     0,
+			OldSize:     0,
+			OldRoot:     root,
+			Key:         0,
+			Value:       0,
+			NextKey:     0,
+			Siblings:    zeros(),
+			LowKey:      0,
+			LowValue:    0,
+			LowIndex:    0,
+			LowSiblings: zeros(),
+			Update:      0,
+		},
+		OldSiblings: zeros(),
+	}
+}
+
+func (c *BatchMutateCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Mutations[0].OldRoot, c.OldRoot)
+	newRoot := BatchMutate{Mutations: c.Mutations, KeyBits: c.KeyBits}.NewRoot(api)
+	api.AssertIsEqual(newRoot, c.NewRoot)
+	return nil
+}
@@ -0,0 +1,282 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+	"github.com/mdehoog/poseidon/circuits/poseidon"
+)
+
+// BatchExclusion proves a set of keys absent from a tree. Each distinct low
+// node is proven included once, in LowNodes, and each key selects the low node
+// it falls after by its position in LowNodes. Zero keys are ignored, as zero
+// can never be excluded.
+//
+// The low node paths also share the top levels of the tree. With m low node
+// slots, each path is hashed up to depth k = ceil(log2 m), and the 2^k hashes
+// at that depth are hashed up to the root once, like a multiproof: a hash on a
+// low node's path is computed, and any other is taken from Upper, which holds
+// the hashes of the top k levels by depth, then position. This replaces m*k
+// path hashes with 2^k - 1.
+type BatchExclusion struct {
+	Root         frontend.Variable
+	Size         frontend.Variable
+	Keys         []frontend.Variable
+	LowNodeIndex []frontend.Variable // per key: the position of its low node in LowNodes
+	LowNodes     []LowNode           // siblings below the top k levels
+	Upper        []frontend.Variable // 2^(k+1) - 1 hashes of the top k levels
+	KeyBits      int
+}
+
+type LowNode struct {
+	Enabled  frontend.Variable
+	Key      frontend.Variable
+	Value    frontend.Variable
+	Index    frontend.Variable
+	NextKey  frontend.Variable
+	Siblings []frontend.Variable
+}
+
+func (v BatchExclusion) Run(api frontend.API) {
+	if len(v.Keys) != len(v.LowNodeIndex) {
+		panic("key length mismatch")
+	}
+	upper := bits.Len(uint(len(v.Upper))) - 1
+	if upper < 0 || len(v.Upper) != 1<<(upper+1)-1 {
+		panic("invalid upper levels")
+	}
+
+	// the keys are checked against each key below, so only the paths are
+	// verified, which also allows the key 0 node of an empty tree
+	hashes := make([]frontend.Variable, len(v.LowNodes))
+	positions := make([]frontend.Variable, len(v.LowNodes))
+	for j, n := range v.LowNodes {
+		api.AssertIsBoolean(n.Enabled)
+		levels := upper + len(n.Siblings)
+
+		// the tree is within capacity, and the node is one of its size + 1 nodes
+		api.ToBinary(api.Mul(n.Enabled, v.Size), levels)                   // size < 2^levels
+		api.ToBinary(api.Mul(n.Enabled, api.Sub(v.Size, n.Index)), levels) // index <= size
+
+		indexBits := api.ToBinary(n.Index, levels)
+		h := poseidon.Hash(api, []frontend.Variable{n.Key, n.Value, n.NextKey})
+		for i := 0; i < len(n.Siblings); i++ {
+			h = hashSwitcher(api, indexBits[i], h, n.Siblings[len(n.Siblings)-i-1])
+		}
+		var position frontend.Variable = 0
+		for i, b := range indexBits[len(n.Siblings):] {
+			position = api.Add(position, api.Mul(b, 1<<i))
+		}
+		hashes[j] = h
+		positions[j] = position
+	}
+
+	// at depth k, each position takes the hash of the first low node under
+	// it, which every other low node under it must agree with
+	level := make([]frontend.Variable, 1<<upper)
+	onPath := make([]frontend.Variable, 1<<upper)
+	for p := range level {
+		var found, h frontend.Variable = 0, 0
+		for j, n := range v.LowNodes {
+			s := api.Mul(n.Enabled, api.IsZero(api.Sub(positions[j], p)))
+			first := api.Mul(s, api.Sub(1, found))
+			h = api.Add(h, api.Mul(first, hashes[j]))
+			api.AssertIsEqual(api.Mul(s, api.Sub(h, hashes[j])), 0)
+			found = api.Add(found, first)
+		}
+		onPath[p] = found
+		level[p] = api.Select(found, h, v.Upper[1<<upper-1+p])
+	}
+
+	// hash the top levels once, with the same empty sibling semantics as the
+	// paths
+	for d := upper - 1; d >= 0; d-- {
+		for p := 0; p < 1<<d; p++ {
+			l, r := level[2*p], level[2*p+1]
+			h := api.Select(api.IsZero(r), l,
+				api.Select(api.IsZero(l), r, poseidon.Hash(api, []frontend.Variable{l, r})))
+			onPath[p] = api.Or(onPath[2*p], onPath[2*p+1])
+			level[p] = api.Select(onPath[p], h, v.Upper[1<<d-1+p])
+		}
+	}
+	h := poseidon.Hash(api, []frontend.Variable{level[0], v.Size})
+	assertEqualIfEnabled(api, h, v.Root, onPath[0])
+
+	for i, key := range v.Keys {
+		enabled := api.Sub(1, api.IsZero(key))
+
+		// one-hot select the low node
+		var selected, lowKey, nextKey frontend.Variable = 0, 0, 0
+		for j, n := range v.LowNodes {
+			s := api.IsZero(api.Sub(v.LowNodeIndex[i], j))
+			selected = api.Add(selected, api.Mul(s, n.Enabled))
+			lowKey = api.Add(lowKey, api.Mul(s, n.Key))
+			nextKey = api.Add(nextKey, api.Mul(s, n.NextKey))
+		}
+		assertEqualIfEnabled(api, selected, 1, enabled)
+
+		assertDifferentIfEnabled(api, key, lowKey, enabled)  // key != lowKey
+		assertDifferentIfEnabled(api, key, nextKey, enabled) // key != nextKey
+		nextKeyBound := nextKeyBound(api, nextKey, v.KeyBits)
+		assertOrderedIfEnabled(api, enabled, v.KeyBits, lowKey, key, nextKeyBound) // lowKey <= key <= nextKey
+	}
+}
+
+type BatchExclusionCircuit struct {
+	Root         frontend.Variable   `gnark:",public"`
+	Keys         []frontend.Variable `gnark:",public"`
+	Size         frontend.Variable
+	LowNodeIndex []frontend.Variable
+	LowNodes     []LowNode
+	Upper        []frontend.Variable
+	KeyBits      int
+}
+
+// NewBatchExclusionCircuit returns a circuit proving up to n keys absent, which
+// fall after up to m distinct low nodes.
+func NewBatchExclusionCircuit(n, m, levels int) *BatchExclusionCircuit {
+	upper := upperLevels(m, levels)
+	c := &BatchExclusionCircuit{
+		Keys:         make([]frontend.Variable, n),
+		LowNodeIndex: make([]frontend.Variable, n),
+		LowNodes:     make([]LowNode, m),
+		Upper:        make([]frontend.Variable, 1<<(upper+1)-1),
+	}
+	for i := range c.LowNodes {
+		c.LowNodes[i].Siblings = make([]frontend.Variable, levels-upper)
+	}
+	return c
+}
+
+// upperLevels returns the number of top levels shared by m low node paths in a
+// tree with the given number of levels: ceil(log2 m), at most levels.
+func upperLevels(m, levels int) int {
+	if m <= 1 {
+		return 0
+	}
+	return min(bits.Len(uint(m-1)), levels)
+}
+
+func NewBatchExclusionAssignment(proofs []native.Proof, keys []*big.Int, n, m, levels int) (*BatchExclusionCircuit, error) {
+	v, err := NewBatchExclusion(proofs, keys, n, m, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchExclusionCircuit{
+		Root:         v.Root,
+		Keys:         v.Keys,
+		Size:         v.Size,
+		LowNodeIndex: v.LowNodeIndex,
+		LowNodes:     v.LowNodes,
+		Upper:        v.Upper,
+	}, nil
+}
+
+func (c *BatchExclusionCircuit) Define(api frontend.API) error {
+	BatchExclusion{
+		Root:         c.Root,
+		Size:         c.Size,
+		Keys:         c.Keys,
+		LowNodeIndex: c.LowNodeIndex,
+		LowNodes:     c.LowNodes,
+		Upper:        c.Upper,
+		KeyBits:      c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+// NewBatchExclusion returns the assignment of a BatchExclusion of up to n keys
+// and m low nodes, from the exclusion proof of each key against the same root.
+// The hashes of the shared top levels are taken from the proofs' siblings.
+func NewBatchExclusion(proofs []native.Proof, keys []*big.Int, n, m, levels int) (BatchExclusion, error) {
+	if len(proofs) != len(keys) {
+		return BatchExclusion{}, errors.New("proof and key length mismatch")
+	}
+	if len(keys) == 0 {
+		return BatchExclusion{}, errors.New("empty batch")
+	}
+	if len(keys) > n {
+		return BatchExclusion{}, fmt.Errorf("batch has %d keys, circuit has %d", len(keys), n)
+	}
+	upper := upperLevels(m, levels)
+	v := BatchExclusion{
+		Root:         proofs[0].Root(),
+		Size:         proofs[0].Size(),
+		Keys:         make([]frontend.Variable, n),
+		LowNodeIndex: make([]frontend.Variable, n),
+		Upper:        make([]frontend.Variable, 1<<(upper+1)-1),
+	}
+	for i := range v.Upper {
+		v.Upper[i] = 0
+	}
+	slots := make(map[string]int)
+	var siblings [][]frontend.Variable
+	var indices []uint64
+	for i, p := range proofs {
+		if p.Root().Cmp(proofs[0].Root()) != 0 {
+			return BatchExclusion{}, errors.New("proofs are against different roots")
+		}
+		e, err := NewExclusion(p, keys[i], levels)
+		if err != nil {
+			return BatchExclusion{}, err
+		}
+		lowKey := p.Node().Key().String()
+		slot, ok := slots[lowKey]
+		if !ok {
+			slot = len(v.LowNodes)
+			if slot == m {
+				return BatchExclusion{}, fmt.Errorf("batch has more than %d low nodes", m)
+			}
+			slots[lowKey] = slot
+			v.LowNodes = append(v.LowNodes, LowNode{
+				Enabled:  1,
+				Key:      e.LowKey,
+				Value:    e.LowValue,
+				Index:    e.Index,
+				NextKey:  e.LowNextKey,
+				Siblings: e.Siblings[upper:],
+			})
+			siblings = append(siblings, e.Siblings[:upper])
+			indices = append(indices, p.Node().Index())
+		}
+		v.Keys[i] = e.Key
+		v.LowNodeIndex[i] = slot
+	}
+	// the siblings of the paths in the top levels, except where the paths meet
+	onPath := make(map[int]bool)
+	for _, index := range indices {
+		for d := 0; d <= upper; d++ {
+			onPath[1<<d-1+int(index>>(levels-d))] = true
+		}
+	}
+	for j, index := range indices {
+		for d := 1; d <= upper; d++ {
+			if i := 1<<d - 1 + int(index>>(levels-d)^1); !onPath[i] {
+				v.Upper[i] = siblings[j][d-1]
+			}
+		}
+	}
+	for i := len(keys); i < n; i++ {
+		v.Keys[i] = 0
+		v.LowNodeIndex[i] = 0
+	}
+	for len(v.LowNodes) < m {
+		siblings := make([]frontend.Variable, levels-upper)
+		for i := range siblings {
+			siblings[i] = 0
+		}
+		v.LowNodes = append(v.LowNodes, LowNode{
+			Enabled:  0,
+			Key:      0,
+			Value:    0,
+			Index:    0,
+			NextKey:  0,
+			Siblings: siblings,
+		})
+	}
+	return v, nil
+}
@@ -0,0 +1,160 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+func TestBatchExclusion(t *testing.T) {
+	const levels, n, m = 5, 6, 3
+	w := testWriter(t, levels)
+	for _, k := range []int64{10, 20, 30} {
+		if _, err := w.Insert(big.NewInt(k), big.NewInt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []*big.Int{big.NewInt(11), big.NewInt(15), big.NewInt(5), big.NewInt(40)}
+	var proofs []native.Proof
+	for _, k := range keys {
+		p, err := w.ProveExclusion(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, p)
+	}
+
+	if _, err := NewBatchExclusionAssignment(proofs, keys, n, 2, levels); err == nil {
+		t.Fatal("expected too many low nodes to fail")
+	}
+	a, err := NewBatchExclusionAssignment(proofs[:3], keys[:3], n, m, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Keys[1] = big.NewInt(25) // not after the selected low node
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("key beyond its low node's next key accepted")
+	}
+	a.Keys[1] = big.NewInt(20) // the low node's next key
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("included key accepted")
+	}
+	a.Keys[1] = big.NewInt(10) // the low node's key
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("low node key accepted")
+	}
+
+	a, err = NewBatchExclusionAssignment(proofs[:3], keys[:3], n, m, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.LowNodeIndex[0] = 2 // a disabled slot
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("disabled low node accepted")
+	}
+
+	a, err = NewBatchExclusionAssignment(proofs[1:], keys[1:], n, m, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchExclusionEmptyTree(t *testing.T) {
+	const levels, n, m = 5, 2, 1
+	w := testWriter(t, levels)
+	keys := []*big.Int{big.NewInt(5), big.NewInt(7)}
+	var proofs []native.Proof
+	for _, k := range keys {
+		p, err := w.ProveExclusion(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, p)
+	}
+
+	e, err := NewExclusionAssignment(proofs[0], keys[0], levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewExclusionCircuit(levels), e); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewBatchExclusionAssignment(proofs, keys, n, m, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchExclusionUpperLevels(t *testing.T) {
+	const levels, n, m = 5, 4, 4
+	w := testWriter(t, levels)
+	for k := int64(1); k <= 12; k++ {
+		if _, err := w.Insert(big.NewInt(k*10), big.NewInt(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// low nodes 10 and 20 share a subtree at depth 2, 70 and 120 are in others
+	keys := []*big.Int{big.NewInt(15), big.NewInt(25), big.NewInt(75), big.NewInt(125)}
+	var proofs []native.Proof
+	for _, k := range keys {
+		p, err := w.ProveExclusion(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, p)
+	}
+	newAssignment := func() *BatchExclusionCircuit {
+		a, err := NewBatchExclusionAssignment(proofs, keys, n, m, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	a := newAssignment()
+	if len(a.Upper) != 7 || len(a.LowNodes[0].Siblings) != levels-2 {
+		t.Fatalf("expected 2 upper levels, got %d hashes", len(a.Upper))
+	}
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err != nil {
+		t.Fatal(err)
+	}
+
+	// every sibling of the paths in the top levels is used
+	used := 0
+	for i, u := range a.Upper {
+		if _, ok := u.(int); ok {
+			continue
+		}
+		used++
+		a := newAssignment()
+		a.Upper[i] = big.NewInt(1)
+		if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+			t.Fatalf("wrong upper hash %d accepted", i)
+		}
+	}
+	if used == 0 {
+		t.Fatal("no upper hashes used")
+	}
+
+	a = newAssignment()
+	a.LowNodes[1].Siblings[len(a.LowNodes[1].Siblings)-1] = big.NewInt(1)
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("wrong low node sibling accepted")
+	}
+	a = newAssignment()
+	a.LowNodes[1].Value = big.NewInt(7) // disagrees with the other path through its subtree
+	if err := isSolved(NewBatchExclusionCircuit(n, m, levels), a); err == nil {
+		t.Fatal("wrong low node accepted")
+	}
+}
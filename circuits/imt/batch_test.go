@@ -0,0 +1,48 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+func TestBatchMutate(t *testing.T) {
+	const levels, n = 5, 6
+	w := testWriter(t, levels)
+	var proofs []native.MutateProof
+	for _, kv := range [][2]int64{{10, 1}, {30, 2}, {20, 3}, {10, 4}} {
+		mp, err := w.Set(big.NewInt(kv[0]), big.NewInt(kv[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, mp)
+	}
+
+	a, err := NewBatchMutateAssignment(proofs, n, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewBatchMutateCircuit(n, levels), a); err != nil {
+		t.Fatal(err)
+	}
+	a.NewRoot = proofs[2].NewRoot()
+	if err := isSolved(NewBatchMutateCircuit(n, levels), a); err == nil {
+		t.Fatal("batch accepted with an intermediate root as the new root")
+	}
+
+	a, err = NewBatchMutateAssignment(proofs[1:], n, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewBatchMutateCircuit(n, levels), a); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBatchMutateAssignment([]native.MutateProof{proofs[0], proofs[2]}, n, levels); err == nil {
+		t.Fatal("expected unchained proofs to fail")
+	}
+	if _, err := NewBatchMutateAssignment(proofs, 3, levels); err == nil {
+		t.Fatal("expected too many proofs to fail")
+	}
+}
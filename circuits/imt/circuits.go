@@ -0,0 +1,322 @@
+package imt
+
+import (
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+// The circuits below wrap each gadget with its roots and keys as public
+// inputs. NewXCircuit returns the circuit to compile for a number of levels,
+// and NewXAssignment its assignment from a native proof. Setting KeyBits on
+// the circuit before compiling bounds keys to that many bits, which makes key
+// comparisons cheaper.
+
+type InclusionCircuit struct {
+	Root                        frontend.Variable `gnark:",public"`
+	Key                         frontend.Variable `gnark:",public"`
+	Size, Value, Index, NextKey frontend.Variable
+	Siblings                    []frontend.Variable
+	KeyBits                     int
+}
+
+func NewInclusionCircuit(levels int) *InclusionCircuit {
+	return &InclusionCircuit{Siblings: make([]frontend.Variable, levels)}
+}
+
+func NewInclusionAssignment(p native.Proof, levels int) (*InclusionCircuit, error) {
+	v, err := NewInclusion(p, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &InclusionCircuit{
+		Root:     v.Root,
+		Key:      v.Key,
+		Size:     v.Size,
+		Value:    v.Value,
+		Index:    v.Index,
+		NextKey:  v.NextKey,
+		Siblings: v.Siblings,
+	}, nil
+}
+
+func (c *InclusionCircuit) Define(api frontend.API) error {
+	Inclusion{
+		Enabled:  1,
+		Root:     c.Root,
+		Size:     c.Size,
+		Key:      c.Key,
+		Value:    c.Value,
+		Index:    c.Index,
+		NextKey:  c.NextKey,
+		Siblings: c.Siblings,
+		KeyBits:  c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+type ExclusionCircuit struct {
+	Root                                      frontend.Variable `gnark:",public"`
+	Key                                       frontend.Variable `gnark:",public"`
+	Size, Index, LowKey, LowValue, LowNextKey frontend.Variable
+	Siblings                                  []frontend.Variable
+	KeyBits                                   int
+}
+
+func NewExclusionCircuit(levels int) *ExclusionCircuit {
+	return &ExclusionCircuit{Siblings: make([]frontend.Variable, levels)}
+}
+
+func NewExclusionAssignment(p native.Proof, key *big.Int, levels int) (*ExclusionCircuit, error) {
+	v, err := NewExclusion(p, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &ExclusionCircuit{
+		Root:       v.Root,
+		Key:        v.Key,
+		Size:       v.Size,
+		Index:      v.Index,
+		LowKey:     v.LowKey,
+		LowValue:   v.LowValue,
+		LowNextKey: v.LowNextKey,
+		Siblings:   v.Siblings,
+	}, nil
+}
+
+func (c *ExclusionCircuit) Define(api frontend.API) error {
+	Exclusion{
+		Enabled:    1,
+		Root:       c.Root,
+		Size:       c.Size,
+		Key:        c.Key,
+		Index:      c.Index,
+		LowKey:     c.LowKey,
+		LowValue:   c.LowValue,
+		LowNextKey: c.LowNextKey,
+		Siblings:   c.Siblings,
+		KeyBits:    c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+type VerifyCircuit struct {
+	Root                                frontend.Variable `gnark:",public"`
+	Key                                 frontend.Variable `gnark:",public"`
+	Inclusion                           frontend.Variable `gnark:",public"`
+	Size, LowKey, Value, NextKey, Index frontend.Variable
+	Siblings                            []frontend.Variable
+	KeyBits                             int
+}
+
+func NewVerifyCircuit(levels int) *VerifyCircuit {
+	return &VerifyCircuit{Siblings: make([]frontend.Variable, levels)}
+}
+
+func NewVerifyAssignment(p native.Proof, key *big.Int, levels int) (*VerifyCircuit, error) {
+	v, err := NewVerify(p, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyCircuit{
+		Root:      v.Root,
+		Key:       v.Key,
+		Inclusion: v.Inclusion,
+		Size:      v.Size,
+		LowKey:    v.LowKey,
+		Value:     v.Value,
+		NextKey:   v.NextKey,
+		Index:     v.Index,
+		Siblings:  v.Siblings,
+	}, nil
+}
+
+func (c *VerifyCircuit) Define(api frontend.API) error {
+	Verify{
+		Enabled:   1,
+		Root:      c.Root,
+		Size:      c.Size,
+		Key:       c.Key,
+		Value:     c.Value,
+		Index:     c.Index,
+		NextKey:   c.NextKey,
+		LowKey:    c.LowKey,
+		Siblings:  c.Siblings,
+		Inclusion: c.Inclusion,
+		KeyBits:   c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+type InsertCircuit struct {
+	OldRoot                                             frontend.Variable `gnark:",public"`
+	NewRoot                                             frontend.Variable `gnark:",public"`
+	Key                                                 frontend.Variable `gnark:",public"`
+	OldSize, Value, NextKey, LowKey, LowValue, LowIndex frontend.Variable
+	OldSiblings, Siblings, LowSiblings                  []frontend.Variable
+	KeyBits                                             int
+}
+
+func NewInsertCircuit(levels int) *InsertCircuit {
+	return &InsertCircuit{
+		OldSiblings: make([]frontend.Variable, levels),
+		Siblings:    make([]frontend.Variable, levels),
+		LowSiblings: make([]frontend.Variable, levels),
+	}
+}
+
+func NewInsertAssignment(p native.MutateProof, levels int) (*InsertCircuit, error) {
+	v, err := NewInsertWithVerify(p, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertCircuit{
+		OldRoot:     v.OldRoot,
+		NewRoot:     p.NewRoot(),
+		Key:         v.Key,
+		OldSize:     v.OldSize,
+		Value:       v.Value,
+		NextKey:     v.NextKey,
+		LowKey:      v.LowKey,
+		LowValue:    v.LowValue,
+		LowIndex:    v.LowIndex,
+		OldSiblings: v.OldSiblings,
+		Siblings:    v.Siblings,
+		LowSiblings: v.LowSiblings,
+	}, nil
+}
+
+func (c *InsertCircuit) Define(api frontend.API) error {
+	newRoot := InsertWithVerify{
+		Insert: Insert{
+			Enabled:     1,
+			OldSize:     c.OldSize,
+			OldRoot:     c.OldRoot,
+			Key:         c.Key,
+			Value:       c.Value,
+			NextKey:     c.NextKey,
+			Siblings:    c.Siblings,
+			LowKey:      c.LowKey,
+			LowValue:    c.LowValue,
+			LowIndex:    c.LowIndex,
+			LowSiblings: c.LowSiblings,
+		},
+		OldSiblings: c.OldSiblings,
+		KeyBits:     c.KeyBits,
+	}.NewRoot(api)
+	api.AssertIsEqual(newRoot, c.NewRoot)
+	return nil
+}
+
+type UpdateCircuit struct {
+	OldRoot                               frontend.Variable `gnark:",public"`
+	NewRoot                               frontend.Variable `gnark:",public"`
+	Key                                   frontend.Variable `gnark:",public"`
+	Size, Value, NextKey, Index, OldValue frontend.Variable
+	Siblings                              []frontend.Variable
+	KeyBits                               int
+}
+
+func NewUpdateCircuit(levels int) *UpdateCircuit {
+	return &UpdateCircuit{Siblings: make([]frontend.Variable, levels)}
+}
+
+func NewUpdateAssignment(p native.MutateProof, levels int) (*UpdateCircuit, error) {
+	v, err := NewUpdateWithVerify(p, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateCircuit{
+		OldRoot:  v.OldRoot,
+		NewRoot:  p.NewRoot(),
+		Key:      v.Key,
+		Size:     v.Size,
+		Value:    v.Value,
+		NextKey:  v.NextKey,
+		Index:    v.Index,
+		OldValue: v.OldValue,
+		Siblings: v.Siblings,
+	}, nil
+}
+
+func (c *UpdateCircuit) Define(api frontend.API) error {
+	newRoot := UpdateWithVerify{
+		Update: Update{
+			Enabled:  1,
+			Size:     c.Size,
+			OldRoot:  c.OldRoot,
+			Key:      c.Key,
+			Value:    c.Value,
+			NextKey:  c.NextKey,
+			Index:    c.Index,
+			Siblings: c.Siblings,
+		},
+		OldValue: c.OldValue,
+		KeyBits:  c.KeyBits,
+	}.NewRoot(api)
+	api.AssertIsEqual(newRoot, c.NewRoot)
+	return nil
+}
+
+type MutateCircuit struct {
+	OldRoot                                                     frontend.Variable `gnark:",public"`
+	NewRoot                                                     frontend.Variable `gnark:",public"`
+	Key                                                         frontend.Variable `gnark:",public"`
+	OldSize, Value, NextKey, LowKey, LowValue, LowIndex, Update frontend.Variable
+	OldSiblings, Siblings, LowSiblings                          []frontend.Variable
+	KeyBits                                                     int
+}
+
+func NewMutateCircuit(levels int) *MutateCircuit {
+	return &MutateCircuit{
+		OldSiblings: make([]frontend.Variable, levels),
+		Siblings:    make([]frontend.Variable, levels),
+		LowSiblings: make([]frontend.Variable, levels),
+	}
+}
+
+func NewMutateAssignment(p native.MutateProof, levels int) (*MutateCircuit, error) {
+	v, err := NewMutateWithVerify(p, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &MutateCircuit{
+		OldRoot:     v.OldRoot,
+		NewRoot:     p.NewRoot(),
+		Key:         v.Key,
+		OldSize:     v.OldSize,
+		Value:       v.Value,
+		NextKey:     v.NextKey,
+		LowKey:      v.LowKey,
+		LowValue:    v.LowValue,
+		LowIndex:    v.LowIndex,
+		Update:      v.Update,
+		OldSiblings: v.OldSiblings,
+		Siblings:    v.Siblings,
+		LowSiblings: v.LowSiblings,
+	}, nil
+}
+
+func (c *MutateCircuit) Define(api frontend.API) error {
+	newRoot := MutateWithVerify{
+		Mutate: Mutate{
+			Enabled:     1,
+			OldSize:     c.OldSize,
+			OldRoot:     c.OldRoot,
+			Key:         c.Key,
+			Value:       c.Value,
+			NextKey:     c.NextKey,
+			Siblings:    c.Siblings,
+			LowKey:      c.LowKey,
+			LowValue:    c.LowValue,
+			LowIndex:    c.LowIndex,
+			LowSiblings: c.LowSiblings,
+			Update:      c.Update,
+		},
+		OldSiblings: c.OldSiblings,
+		KeyBits:     c.KeyBits,
+	}.NewRoot(api)
+	api.AssertIsEqual(newRoot, c.NewRoot)
+	return nil
+}
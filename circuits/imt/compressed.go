@@ -0,0 +1,159 @@
+package imt
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+	"github.com/mdehoog/poseidon/circuits/poseidon"
+)
+
+// The compressed circuits below prove the same statements as their
+// uncompressed counterparts, but expose a single public input: the Digest of
+// the values the uncompressed circuit exposes, in the same order. A verifier
+// computes the digest natively with NewDigest, or with the NewXDigest helpers
+// from native proofs.
+
+// digest chains the values through 2-input hashes: H(...H(H(v0, v1), v2)..., vn).
+func digest(api frontend.API, values ...frontend.Variable) frontend.Variable {
+	d := values[0]
+	for _, v := range values[1:] {
+		d = poseidon.Hash(api, []frontend.Variable{d, v})
+	}
+	return d
+}
+
+// NewDigest computes the digest of the values natively, with the hash function
+// of the tree.
+func NewDigest(hash native.HashFn, values ...*big.Int) (*big.Int, error) {
+	if len(values) == 0 {
+		return nil, errors.New("no values")
+	}
+	d := values[0]
+	for _, v := range values[1:] {
+		var err error
+		d, err = hash([]*big.Int{d, v})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// CompressedMutateCircuit is a MutateCircuit whose digest is of OldRoot,
+// NewRoot and Key.
+type CompressedMutateCircuit struct {
+	Digest frontend.Variable `gnark:",public"`
+	MutateWithVerify
+}
+
+func NewCompressedMutateCircuit(levels int) *CompressedMutateCircuit {
+	return &CompressedMutateCircuit{MutateWithVerify: emptyMutateWithVerify(levels)}
+}
+
+func NewCompressedMutateAssignment(p native.MutateProof, levels int, hash native.HashFn) (*CompressedMutateCircuit, error) {
+	m, err := NewMutateWithVerify(p, levels)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewMutateDigest(hash, p)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedMutateCircuit{Digest: d, MutateWithVerify: m}, nil
+}
+
+func NewMutateDigest(hash native.HashFn, p native.MutateProof) (*big.Int, error) {
+	return NewDigest(hash, p.OldRoot(), p.NewRoot(), p.Node().Key())
+}
+
+func (c *CompressedMutateCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Enabled, 1)
+	newRoot := c.MutateWithVerify.NewRoot(api)
+	api.AssertIsEqual(digest(api, c.OldRoot, newRoot, c.Key), c.Digest)
+	return nil
+}
+
+// CompressedBatchMutateCircuit is a BatchMutateCircuit whose digest is of
+// OldRoot and NewRoot.
+type CompressedBatchMutateCircuit struct {
+	Digest frontend.Variable `gnark:",public"`
+	BatchMutate
+}
+
+func NewCompressedBatchMutateCircuit(n, levels int) *CompressedBatchMutateCircuit {
+	return &CompressedBatchMutateCircuit{BatchMutate: BatchMutate{Mutations: NewBatchMutateCircuit(n, levels).Mutations}}
+}
+
+func NewCompressedBatchMutateAssignment(proofs []native.MutateProof, n, levels int, hash native.HashFn) (*CompressedBatchMutateCircuit, error) {
+	b, err := NewBatchMutate(proofs, n, levels)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewBatchMutateDigest(hash, proofs)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedBatchMutateCircuit{Digest: d, BatchMutate: b}, nil
+}
+
+func NewBatchMutateDigest(hash native.HashFn, proofs []native.MutateProof) (*big.Int, error) {
+	if len(proofs) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	return NewDigest(hash, proofs[0].OldRoot(), proofs[len(proofs)-1].NewRoot())
+}
+
+func (c *CompressedBatchMutateCircuit) Define(api frontend.API) error {
+	newRoot := c.BatchMutate.NewRoot(api)
+	api.AssertIsEqual(digest(api, c.Mutations[0].OldRoot, newRoot), c.Digest)
+	return nil
+}
+
+// CompressedBatchExclusionCircuit is a BatchExclusionCircuit whose digest is of
+// Root and each of the n Keys, unused keys being zero.
+type CompressedBatchExclusionCircuit struct {
+	Digest frontend.Variable `gnark:",public"`
+	BatchExclusion
+}
+
+func NewCompressedBatchExclusionCircuit(n, m, levels int) *CompressedBatchExclusionCircuit {
+	c := NewBatchExclusionCircuit(n, m, levels)
+	return &CompressedBatchExclusionCircuit{BatchExclusion: BatchExclusion{
+		Keys:         c.Keys,
+		LowNodeIndex: c.LowNodeIndex,
+		LowNodes:     c.LowNodes,
+		Upper:        c.Upper,
+	}}
+}
+
+func NewCompressedBatchExclusionAssignment(proofs []native.Proof, keys []*big.Int, n, m, levels int, hash native.HashFn) (*CompressedBatchExclusionCircuit, error) {
+	v, err := NewBatchExclusion(proofs, keys, n, m, levels)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewBatchExclusionDigest(hash, proofs[0].Root(), keys, n)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedBatchExclusionCircuit{Digest: d, BatchExclusion: v}, nil
+}
+
+func NewBatchExclusionDigest(hash native.HashFn, root *big.Int, keys []*big.Int, n int) (*big.Int, error) {
+	if len(keys) > n {
+		return nil, errors.New("too many keys")
+	}
+	values := []*big.Int{root}
+	values = append(values, keys...)
+	for len(values) < n+1 {
+		values = append(values, new(big.Int))
+	}
+	return NewDigest(hash, values...)
+}
+
+func (c *CompressedBatchExclusionCircuit) Define(api frontend.API) error {
+	c.BatchExclusion.Run(api)
+	api.AssertIsEqual(digest(api, append([]frontend.Variable{c.Root}, c.Keys...)...), c.Digest)
+	return nil
+}
@@ -0,0 +1,105 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+func TestCompressed(t *testing.T) {
+	const levels, n, m = 5, 4, 2
+	w := testWriter(t, levels)
+	var proofs []native.MutateProof
+	for _, kv := range [][2]int64{{10, 1}, {30, 2}, {10, 3}} {
+		mp, err := w.Set(big.NewInt(kv[0]), big.NewInt(kv[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, mp)
+	}
+
+	for _, mp := range proofs {
+		a, err := NewCompressedMutateAssignment(mp, levels, testHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewCompressedMutateCircuit(levels), a); err != nil {
+			t.Fatal(err)
+		}
+		a.Digest = big.NewInt(1)
+		if err := isSolved(NewCompressedMutateCircuit(levels), a); err == nil {
+			t.Fatal("mutation accepted with the wrong digest")
+		}
+	}
+
+	b, err := NewCompressedBatchMutateAssignment(proofs, n, levels, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewCompressedBatchMutateCircuit(n, levels), b); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDigest(testHash, proofs[0].OldRoot(), proofs[1].NewRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Digest = d // of an intermediate root
+	if err := isSolved(NewCompressedBatchMutateCircuit(n, levels), b); err == nil {
+		t.Fatal("batch accepted with the digest of an intermediate root")
+	}
+
+	keys := []*big.Int{big.NewInt(5), big.NewInt(20)}
+	var eps []native.Proof
+	for _, k := range keys {
+		p, err := w.ProveExclusion(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, p)
+	}
+	e, err := NewCompressedBatchExclusionAssignment(eps, keys, n, m, levels, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewCompressedBatchExclusionCircuit(n, m, levels), e); err != nil {
+		t.Fatal(err)
+	}
+	root, err := w.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unused keys are zero in the digest
+	d, err = NewDigest(testHash, root, keys[0], keys[1], new(big.Int), new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Cmp(e.Digest.(*big.Int)) != 0 {
+		t.Fatal("digest is not of the root and the padded keys")
+	}
+	e.Digest, err = NewDigest(testHash, root, keys[1], keys[0], new(big.Int), new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewCompressedBatchExclusionCircuit(n, m, levels), e); err == nil {
+		t.Fatal("batch exclusion accepted with the digest of reordered keys")
+	}
+
+	// the digest is the only public input
+	for _, a := range []frontend.Circuit{
+		&CompressedMutateCircuit{Digest: 1, MutateWithVerify: emptyMutateWithVerify(levels)},
+		&CompressedBatchMutateCircuit{Digest: 1, BatchMutate: b.BatchMutate},
+		&CompressedBatchExclusionCircuit{Digest: 1, BatchExclusion: e.BatchExclusion},
+	} {
+		pw, err := frontend.NewWitness(a, ecc.BN254.ScalarField(), frontend.PublicOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := pw.Vector().(fr.Vector); !ok || len(v) != 1 {
+			t.Fatalf("%T has %d public inputs", a, len(v))
+		}
+	}
+}
@@ -12,6 +12,7 @@ type Exclusion struct {
 	LowValue   frontend.Variable
 	LowNextKey frontend.Variable
 	Siblings   []frontend.Variable
+	KeyBits    int
 }
 
 func (v Exclusion) Run(api frontend.API) {
@@ -26,5 +27,6 @@ func (v Exclusion) Run(api frontend.API) {
 		LowKey:    v.LowKey,
 		Siblings:  v.Siblings,
 		Inclusion: 0,
+		KeyBits:   v.KeyBits,
 	}.Run(api)
 }
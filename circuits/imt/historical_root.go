@@ -0,0 +1,122 @@
+package imt
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+	"github.com/mdehoog/poseidon/circuits/poseidon"
+)
+
+// HistoricalRoot proves that Root is the root at Index of a root history, so
+// that proofs can be made against any root the tree has committed.
+type HistoricalRoot struct {
+	Enabled     frontend.Variable
+	HistoryRoot frontend.Variable
+	HistorySize frontend.Variable
+	Root        frontend.Variable
+	Index       frontend.Variable
+	Siblings    []frontend.Variable
+}
+
+func (v HistoricalRoot) Run(api frontend.API) {
+	api.ToBinary(api.Mul(v.Enabled, api.Sub(api.Sub(v.HistorySize, 1), v.Index)), len(v.Siblings)) // index < size
+
+	indexBits := api.ToBinary(v.Index, len(v.Siblings))
+	h := poseidon.Hash(api, []frontend.Variable{v.Root})
+	for i := 0; i < len(v.Siblings); i++ {
+		level := len(v.Siblings) - i - 1
+		h = hashSwitcher(api, indexBits[i], h, v.Siblings[level])
+	}
+	h = poseidon.Hash(api, []frontend.Variable{h, v.HistorySize})
+	assertEqualIfEnabled(api, h, v.HistoryRoot, v.Enabled)
+}
+
+// HistoricalExclusionCircuit proves a key absent from a tree at any of the
+// roots in a root history.
+type HistoricalExclusionCircuit struct {
+	HistoryRoot                                     frontend.Variable `gnark:",public"`
+	Key                                             frontend.Variable `gnark:",public"`
+	HistorySize, HistoryIndex                       frontend.Variable
+	HistorySiblings                                 []frontend.Variable
+	Root, Size, Index, LowKey, LowValue, LowNextKey frontend.Variable
+	Siblings                                        []frontend.Variable
+	KeyBits                                         int
+}
+
+func NewHistoricalExclusionCircuit(levels, historyLevels int) *HistoricalExclusionCircuit {
+	return &HistoricalExclusionCircuit{
+		HistorySiblings: make([]frontend.Variable, historyLevels),
+		Siblings:        make([]frontend.Variable, levels),
+	}
+}
+
+func NewHistoricalExclusionAssignment(p native.Proof, key *big.Int, h native.RootHistoryProof, levels, historyLevels int) (*HistoricalExclusionCircuit, error) {
+	v, err := NewExclusion(p, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewHistoricalRoot(h, historyLevels)
+	if err != nil {
+		return nil, err
+	}
+	if h.Root().Cmp(p.Root()) != 0 {
+		return nil, errors.New("proof is not against the historical root")
+	}
+	return &HistoricalExclusionCircuit{
+		HistoryRoot:     r.HistoryRoot,
+		Key:             v.Key,
+		HistorySize:     r.HistorySize,
+		HistoryIndex:    r.Index,
+		HistorySiblings: r.Siblings,
+		Root:            v.Root,
+		Size:            v.Size,
+		Index:           v.Index,
+		LowKey:          v.LowKey,
+		LowValue:        v.LowValue,
+		LowNextKey:      v.LowNextKey,
+		Siblings:        v.Siblings,
+	}, nil
+}
+
+func (c *HistoricalExclusionCircuit) Define(api frontend.API) error {
+	HistoricalRoot{
+		Enabled:     1,
+		HistoryRoot: c.HistoryRoot,
+		HistorySize: c.HistorySize,
+		Root:        c.Root,
+		Index:       c.HistoryIndex,
+		Siblings:    c.HistorySiblings,
+	}.Run(api)
+	Exclusion{
+		Enabled:    1,
+		Root:       c.Root,
+		Size:       c.Size,
+		Key:        c.Key,
+		Index:      c.Index,
+		LowKey:     c.LowKey,
+		LowValue:   c.LowValue,
+		LowNextKey: c.LowNextKey,
+		Siblings:   c.Siblings,
+		KeyBits:    c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+// NewHistoricalRoot returns the assignment of a HistoricalRoot from a proof
+// returned by RootHistory.Prove.
+func NewHistoricalRoot(p native.RootHistoryProof, levels int) (HistoricalRoot, error) {
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return HistoricalRoot{}, err
+	}
+	return HistoricalRoot{
+		Enabled:     1,
+		HistoryRoot: p.HistoryRoot(),
+		HistorySize: p.HistorySize(),
+		Root:        p.Root(),
+		Index:       p.Index(),
+		Siblings:    siblings,
+	}, nil
+}
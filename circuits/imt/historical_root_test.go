@@ -0,0 +1,77 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+func TestHistoricalExclusion(t *testing.T) {
+	const levels, historyLevels = 6, 4
+	tree, err := native.NewTree(testDB(t), levels, fr.Bytes, testHash, native.WithRootHistory(historyLevels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proofs []native.Proof
+	for i := int64(1); i <= 6; i++ {
+		err := tree.Update(func(w native.TreeWriter) error {
+			_, err := w.Insert(big.NewInt(i*10), big.NewInt(i))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := tree.ProveExclusion(big.NewInt(i*10 + 5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, p)
+	}
+
+	for i, p := range proofs {
+		key := big.NewInt(int64(i+1)*10 + 5)
+		hp, err := tree.ProveHistory(p.Root())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a circuit with more history levels than the history
+		a, err := NewHistoricalExclusionAssignment(p, key, hp, levels, historyLevels+2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewHistoricalExclusionCircuit(levels, historyLevels+2), a); err != nil {
+			t.Fatalf("root %d: %v", i, err)
+		}
+		a.HistoryIndex = i + 16 // through empty siblings
+		if err := isSolved(NewHistoricalExclusionCircuit(levels, historyLevels+2), a); err == nil {
+			t.Fatalf("root %d accepted beyond the history size", i)
+		}
+
+		a, err = NewHistoricalExclusionAssignment(p, key, hp, levels, historyLevels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.HistorySize = i
+		if err := isSolved(NewHistoricalExclusionCircuit(levels, historyLevels), a); err == nil {
+			t.Fatalf("root %d accepted with a smaller history size", i)
+		}
+		a, err = NewHistoricalExclusionAssignment(p, key, hp, levels, historyLevels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Root = proofs[(i+1)%len(proofs)].Root()
+		if err := isSolved(NewHistoricalExclusionCircuit(levels, historyLevels), a); err == nil {
+			t.Fatalf("root %d accepted with another root", i)
+		}
+	}
+
+	hp, err := tree.ProveHistory(proofs[0].Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHistoricalExclusionAssignment(proofs[1], big.NewInt(25), hp, levels, historyLevels); err == nil {
+		t.Fatal("expected an error for a proof against another root")
+	}
+}
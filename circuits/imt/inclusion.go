@@ -11,6 +11,7 @@ type Inclusion struct {
 	Index    frontend.Variable
 	NextKey  frontend.Variable
 	Siblings []frontend.Variable
+	KeyBits  int
 }
 
 func (v Inclusion) Run(api frontend.API) {
@@ -25,5 +26,6 @@ func (v Inclusion) Run(api frontend.API) {
 		LowKey:    v.Key,
 		Siblings:  v.Siblings,
 		Inclusion: 1,
+		KeyBits:   v.KeyBits,
 	}.Run(api)
 }
@@ -36,6 +36,7 @@ func (p Insert) NewRoot(api frontend.API) frontend.Variable {
 type InsertWithVerify struct {
 	Insert
 	OldSiblings []frontend.Variable
+	KeyBits     int
 }
 
 func (p InsertWithVerify) NewRoot(api frontend.API) frontend.Variable {
@@ -55,5 +56,6 @@ func (p InsertWithVerify) NewRoot(api frontend.API) frontend.Variable {
 			Update:      0,
 		},
 		OldSiblings: p.OldSiblings,
+		KeyBits:     p.KeyBits,
 	}.NewRoot(api)
 }
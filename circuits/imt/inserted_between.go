@@ -0,0 +1,292 @@
+package imt
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+// InsertedBetween proves that Key was absent from a tree at OldRoot and
+// present at NewRoot. As nodes are only appended, the key's index at NewRoot
+// must be beyond OldSize. The keys are compared once, for the exclusion; the
+// inclusion only verifies the path of the key's node.
+//
+// Nothing here relates the two roots: the index check only means something if
+// NewRoot is a later root of the same tree as OldRoot, which the caller must
+// establish, e.g. with HistoricalInsertedBetweenCircuit, which proves both in
+// the tree's root history.
+type InsertedBetween struct {
+	Enabled     frontend.Variable
+	Key         frontend.Variable
+	OldRoot     frontend.Variable
+	OldSize     frontend.Variable
+	LowIndex    frontend.Variable
+	LowKey      frontend.Variable
+	LowValue    frontend.Variable
+	LowNextKey  frontend.Variable
+	OldSiblings []frontend.Variable
+	NewRoot     frontend.Variable
+	NewSize     frontend.Variable
+	Index       frontend.Variable
+	Value       frontend.Variable
+	NextKey     frontend.Variable
+	Siblings    []frontend.Variable
+	KeyBits     int
+}
+
+func (v InsertedBetween) Run(api frontend.API) {
+	Exclusion{
+		Enabled:    v.Enabled,
+		Root:       v.OldRoot,
+		Size:       v.OldSize,
+		Key:        v.Key,
+		Index:      v.LowIndex,
+		LowKey:     v.LowKey,
+		LowValue:   v.LowValue,
+		LowNextKey: v.LowNextKey,
+		Siblings:   v.OldSiblings,
+		KeyBits:    v.KeyBits,
+	}.Run(api)
+	Verify{
+		Enabled:   v.Enabled,
+		Root:      v.NewRoot,
+		Size:      v.NewSize,
+		Key:       v.Key,
+		Value:     v.Value,
+		Index:     v.Index,
+		NextKey:   v.NextKey,
+		LowKey:    v.Key,
+		Siblings:  v.Siblings,
+		Inclusion: 1,
+	}.verifyPath(api)
+
+	api.ToBinary(api.Mul(v.Enabled, api.Sub(api.Sub(v.Index, v.OldSize), 1)), len(v.Siblings)) // index > oldSize
+}
+
+type InsertedBetweenCircuit struct {
+	OldRoot                                         frontend.Variable `gnark:",public"`
+	NewRoot                                         frontend.Variable `gnark:",public"`
+	Key                                             frontend.Variable `gnark:",public"`
+	OldSize, LowIndex, LowKey, LowValue, LowNextKey frontend.Variable
+	NewSize, Index, Value, NextKey                  frontend.Variable
+	OldSiblings, Siblings                           []frontend.Variable
+	KeyBits                                         int
+}
+
+func NewInsertedBetweenCircuit(levels int) *InsertedBetweenCircuit {
+	return &InsertedBetweenCircuit{
+		OldSiblings: make([]frontend.Variable, levels),
+		Siblings:    make([]frontend.Variable, levels),
+	}
+}
+
+func NewInsertedBetweenAssignment(oldTree, newTree native.TreeReader, key *big.Int, levels int) (*InsertedBetweenCircuit, error) {
+	v, err := NewInsertedBetween(oldTree, newTree, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertedBetweenCircuit{
+		OldRoot:     v.OldRoot,
+		NewRoot:     v.NewRoot,
+		Key:         v.Key,
+		OldSize:     v.OldSize,
+		LowIndex:    v.LowIndex,
+		LowKey:      v.LowKey,
+		LowValue:    v.LowValue,
+		LowNextKey:  v.LowNextKey,
+		NewSize:     v.NewSize,
+		Index:       v.Index,
+		Value:       v.Value,
+		NextKey:     v.NextKey,
+		OldSiblings: v.OldSiblings,
+		Siblings:    v.Siblings,
+	}, nil
+}
+
+func (c *InsertedBetweenCircuit) Define(api frontend.API) error {
+	InsertedBetween{
+		Enabled:     1,
+		Key:         c.Key,
+		OldRoot:     c.OldRoot,
+		OldSize:     c.OldSize,
+		LowIndex:    c.LowIndex,
+		LowKey:      c.LowKey,
+		LowValue:    c.LowValue,
+		LowNextKey:  c.LowNextKey,
+		OldSiblings: c.OldSiblings,
+		NewRoot:     c.NewRoot,
+		NewSize:     c.NewSize,
+		Index:       c.Index,
+		Value:       c.Value,
+		NextKey:     c.NextKey,
+		Siblings:    c.Siblings,
+		KeyBits:     c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+// NewInsertedBetween returns the assignment of an InsertedBetween from the
+// exclusion proof of key in oldTree and its inclusion proof in newTree, which
+// must be two versions of the same tree.
+func NewInsertedBetween(oldTree, newTree native.TreeReader, key *big.Int, levels int) (InsertedBetween, error) {
+	ep, err := oldTree.ProveExclusion(key)
+	if err != nil {
+		return InsertedBetween{}, err
+	}
+	ip, err := newTree.ProveInclusion(key)
+	if err != nil {
+		return InsertedBetween{}, err
+	}
+	return newInsertedBetween(ep, ip, key, levels)
+}
+
+func newInsertedBetween(ep, ip native.Proof, key *big.Int, levels int) (InsertedBetween, error) {
+	e, err := NewExclusion(ep, key, levels)
+	if err != nil {
+		return InsertedBetween{}, err
+	}
+	if ip.Node().Key().Cmp(key) != 0 {
+		return InsertedBetween{}, errors.New("proof is not of the node of key")
+	}
+	i, err := NewInclusion(ip, levels)
+	if err != nil {
+		return InsertedBetween{}, err
+	}
+	if ip.Node().Index() <= ep.Size() {
+		return InsertedBetween{}, errors.New("key was not inserted after the old root")
+	}
+	return InsertedBetween{
+		Enabled:     1,
+		Key:         key,
+		OldRoot:     e.Root,
+		OldSize:     e.Size,
+		LowIndex:    e.Index,
+		LowKey:      e.LowKey,
+		LowValue:    e.LowValue,
+		LowNextKey:  e.LowNextKey,
+		OldSiblings: e.Siblings,
+		NewRoot:     i.Root,
+		NewSize:     i.Size,
+		Index:       i.Index,
+		Value:       i.Value,
+		NextKey:     i.NextKey,
+		Siblings:    i.Siblings,
+	}, nil
+}
+
+// HistoricalInsertedBetweenCircuit proves that Key was inserted between two
+// roots of the same tree: both roots are in its root history, OldRoot first,
+// so NewRoot extends OldRoot.
+type HistoricalInsertedBetweenCircuit struct {
+	HistoryRoot                                     frontend.Variable `gnark:",public"`
+	OldRoot                                         frontend.Variable `gnark:",public"`
+	NewRoot                                         frontend.Variable `gnark:",public"`
+	Key                                             frontend.Variable `gnark:",public"`
+	HistorySize, OldHistoryIndex, NewHistoryIndex   frontend.Variable
+	OldHistorySiblings, NewHistorySiblings          []frontend.Variable
+	OldSize, LowIndex, LowKey, LowValue, LowNextKey frontend.Variable
+	NewSize, Index, Value, NextKey                  frontend.Variable
+	OldSiblings, Siblings                           []frontend.Variable
+	KeyBits                                         int
+}
+
+func NewHistoricalInsertedBetweenCircuit(levels, historyLevels int) *HistoricalInsertedBetweenCircuit {
+	return &HistoricalInsertedBetweenCircuit{
+		OldHistorySiblings: make([]frontend.Variable, historyLevels),
+		NewHistorySiblings: make([]frontend.Variable, historyLevels),
+		OldSiblings:        make([]frontend.Variable, levels),
+		Siblings:           make([]frontend.Variable, levels),
+	}
+}
+
+// NewHistoricalInsertedBetweenAssignment returns the assignment of a
+// HistoricalInsertedBetweenCircuit from the exclusion proof of key at the old
+// root, its inclusion proof at the new root, and the proofs of both roots in
+// the same root history.
+func NewHistoricalInsertedBetweenAssignment(exclusion, inclusion native.Proof, key *big.Int, oldHistory, newHistory native.RootHistoryProof, levels, historyLevels int) (*HistoricalInsertedBetweenCircuit, error) {
+	v, err := newInsertedBetween(exclusion, inclusion, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	o, err := NewHistoricalRoot(oldHistory, historyLevels)
+	if err != nil {
+		return nil, err
+	}
+	n, err := NewHistoricalRoot(newHistory, historyLevels)
+	if err != nil {
+		return nil, err
+	}
+	if oldHistory.Root().Cmp(exclusion.Root()) != 0 || newHistory.Root().Cmp(inclusion.Root()) != 0 {
+		return nil, errors.New("proofs are not against the historical roots")
+	}
+	if oldHistory.HistoryRoot().Cmp(newHistory.HistoryRoot()) != 0 || oldHistory.HistorySize() != newHistory.HistorySize() {
+		return nil, errors.New("roots are proven in different histories")
+	}
+	if oldHistory.Index() >= newHistory.Index() {
+		return nil, errors.New("old root is not before the new root in the history")
+	}
+	return &HistoricalInsertedBetweenCircuit{
+		HistoryRoot:        o.HistoryRoot,
+		OldRoot:            v.OldRoot,
+		NewRoot:            v.NewRoot,
+		Key:                v.Key,
+		HistorySize:        o.HistorySize,
+		OldHistoryIndex:    o.Index,
+		NewHistoryIndex:    n.Index,
+		OldHistorySiblings: o.Siblings,
+		NewHistorySiblings: n.Siblings,
+		OldSize:            v.OldSize,
+		LowIndex:           v.LowIndex,
+		LowKey:             v.LowKey,
+		LowValue:           v.LowValue,
+		LowNextKey:         v.LowNextKey,
+		NewSize:            v.NewSize,
+		Index:              v.Index,
+		Value:              v.Value,
+		NextKey:            v.NextKey,
+		OldSiblings:        v.OldSiblings,
+		Siblings:           v.Siblings,
+	}, nil
+}
+
+func (c *HistoricalInsertedBetweenCircuit) Define(api frontend.API) error {
+	HistoricalRoot{
+		Enabled:     1,
+		HistoryRoot: c.HistoryRoot,
+		HistorySize: c.HistorySize,
+		Root:        c.OldRoot,
+		Index:       c.OldHistoryIndex,
+		Siblings:    c.OldHistorySiblings,
+	}.Run(api)
+	HistoricalRoot{
+		Enabled:     1,
+		HistoryRoot: c.HistoryRoot,
+		HistorySize: c.HistorySize,
+		Root:        c.NewRoot,
+		Index:       c.NewHistoryIndex,
+		Siblings:    c.NewHistorySiblings,
+	}.Run(api)
+	api.ToBinary(api.Sub(api.Sub(c.NewHistoryIndex, c.OldHistoryIndex), 1), len(c.NewHistorySiblings)) // oldIndex < newIndex
+
+	InsertedBetween{
+		Enabled:     1,
+		Key:         c.Key,
+		OldRoot:     c.OldRoot,
+		OldSize:     c.OldSize,
+		LowIndex:    c.LowIndex,
+		LowKey:      c.LowKey,
+		LowValue:    c.LowValue,
+		LowNextKey:  c.LowNextKey,
+		OldSiblings: c.OldSiblings,
+		NewRoot:     c.NewRoot,
+		NewSize:     c.NewSize,
+		Index:       c.Index,
+		Value:       c.Value,
+		NextKey:     c.NextKey,
+		Siblings:    c.Siblings,
+		KeyBits:     c.KeyBits,
+	}.Run(api)
+	return nil
+}
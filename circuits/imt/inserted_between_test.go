@@ -0,0 +1,179 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+func TestInsertedBetween(t *testing.T) {
+	const levels = 6
+	build := func(keys ...int64) native.TreeWriter {
+		w := testWriter(t, levels)
+		for _, k := range keys {
+			if _, err := w.Insert(big.NewInt(k), big.NewInt(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+	oldTree := build(10, 30)
+	newTree := build(10, 30, 20, 40, 5)
+
+	for _, bits := range []int{0, 64} {
+		for _, k := range []int64{20, 40, 5} {
+			a, err := NewInsertedBetweenAssignment(oldTree, newTree, big.NewInt(k), levels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := NewInsertedBetweenCircuit(levels)
+			c.KeyBits = bits
+			if err := isSolved(c, a); err != nil {
+				t.Fatalf("%d bits: key %d: %v", bits, k, err)
+			}
+			b := *a
+			b.Key = k + 1
+			if err := isSolved(c, &b); err == nil {
+				t.Fatalf("%d bits: key %d: accepted for another key", bits, k)
+			}
+			b = *a
+			b.OldSize = 4
+			if err := isSolved(c, &b); err == nil {
+				t.Fatalf("%d bits: key %d: accepted at an index within the old size", bits, k)
+			}
+		}
+	}
+
+	if _, err := NewInsertedBetweenAssignment(oldTree, newTree, big.NewInt(10), levels); err == nil {
+		t.Fatal("expected an error for a key in the old tree")
+	}
+	if _, err := NewInsertedBetweenAssignment(oldTree, newTree, big.NewInt(25), levels); err == nil {
+		t.Fatal("expected an error for a key not in the new tree")
+	}
+
+	// a key absent from the old tree, but at an index within its size in
+	// another tree
+	oldTree, newTree = build(10, 30, 40), build(20, 10, 30)
+	if _, err := NewInsertedBetweenAssignment(oldTree, newTree, big.NewInt(20), levels); err == nil {
+		t.Fatal("expected an error for a key at an old index")
+	}
+	ep, err := oldTree.ProveExclusion(big.NewInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExclusion(ep, big.NewInt(20), levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := newTree.ProveInclusion(big.NewInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewInclusion(ip, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &InsertedBetweenCircuit{
+		OldRoot:     e.Root,
+		NewRoot:     i.Root,
+		Key:         20,
+		OldSize:     e.Size,
+		LowIndex:    e.Index,
+		LowKey:      e.LowKey,
+		LowValue:    e.LowValue,
+		LowNextKey:  e.LowNextKey,
+		NewSize:     i.Size,
+		Index:       i.Index,
+		Value:       i.Value,
+		NextKey:     i.NextKey,
+		OldSiblings: e.Siblings,
+		Siblings:    i.Siblings,
+	}
+	if err := isSolved(NewInsertedBetweenCircuit(levels), a); err == nil {
+		t.Fatal("key at an old index accepted")
+	}
+}
+
+func TestHistoricalInsertedBetween(t *testing.T) {
+	const levels, historyLevels = 6, 4
+	tree, err := native.NewTree(testDB(t), levels, fr.Bytes, testHash, native.WithRootHistory(historyLevels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := func(k int64) {
+		err := tree.Update(func(w native.TreeWriter) error {
+			_, err := w.Insert(big.NewInt(k), big.NewInt(k))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(10)
+	insert(30)
+	key := big.NewInt(20)
+	ep, err := tree.ProveExclusion(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(20)
+	insert(40)
+	ip, err := tree.ProveInclusion(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHistory, err := tree.ProveHistory(ep.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHistory, err := tree.ProveHistory(ip.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewHistoricalInsertedBetweenAssignment(ep, ip, key, oldHistory, newHistory, levels, historyLevels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewHistoricalInsertedBetweenCircuit(levels, historyLevels), a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHistoricalInsertedBetweenAssignment(ep, ip, key, newHistory, oldHistory, levels, historyLevels); err == nil {
+		t.Fatal("expected an error for swapped history proofs")
+	}
+
+	// the roots in reverse history order
+	b := *a
+	b.OldHistoryIndex, b.NewHistoryIndex = a.NewHistoryIndex, a.OldHistoryIndex
+	b.OldHistorySiblings, b.NewHistorySiblings = a.NewHistorySiblings, a.OldHistorySiblings
+	b.OldRoot, b.NewRoot = a.NewRoot, a.OldRoot
+	if err := isSolved(NewHistoricalInsertedBetweenCircuit(levels, historyLevels), &b); err == nil {
+		t.Fatal("roots accepted in reverse history order")
+	}
+
+	// a new root from another tree, where the key is at a later index
+	other := testWriter(t, levels)
+	for _, k := range []int64{10, 30, 5, 7, 20} {
+		if _, err := other.Insert(big.NewInt(k), big.NewInt(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	op, err := other.ProveInclusion(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHistoricalInsertedBetweenAssignment(ep, op, key, oldHistory, newHistory, levels, historyLevels); err == nil {
+		t.Fatal("expected an error for a root outside the history")
+	}
+	i, err := NewInclusion(op, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = *a
+	b.NewRoot, b.NewSize, b.Index, b.Value, b.NextKey, b.Siblings = i.Root, i.Size, i.Index, i.Value, i.NextKey, i.Siblings
+	if err := isSolved(NewHistoricalInsertedBetweenCircuit(levels, historyLevels), &b); err == nil {
+		t.Fatal("root outside the history accepted")
+	}
+}
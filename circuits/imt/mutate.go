@@ -31,6 +31,10 @@ func (p Mutate) NewRoot(api frontend.API) frontend.Variable {
 	size := api.Add(p.OldSize, api.IsZero(p.Update))
 	index := api.Select(p.Update, p.LowIndex, size)
 
+	// as natively, the new size must fit, and the low node must be in the tree
+	api.ToBinary(api.Mul(p.Enabled, size), len(p.Siblings))                           // size < 2^levels
+	api.ToBinary(api.Mul(p.Enabled, api.Sub(p.OldSize, p.LowIndex)), len(p.Siblings)) // lowIndex <= oldSize
+
 	lowNextKey := api.Select(p.Update, p.NextKey, p.Key)
 	h := updateNode(api, size, p.Key, p.Value, p.NextKey, index, p.Siblings)
 	lowH := updateNode(api, size, p.LowKey, lowValueUpdate, lowNextKey, p.LowIndex, p.LowSiblings)
@@ -43,6 +47,7 @@ func (p Mutate) NewRoot(api frontend.API) frontend.Variable {
 type MutateWithVerify struct {
 	Mutate
 	OldSiblings []frontend.Variable
+	KeyBits     int
 }
 
 func (p MutateWithVerify) NewRoot(api frontend.API) frontend.Variable {
@@ -60,6 +65,7 @@ func (p MutateWithVerify) NewRoot(api frontend.API) frontend.Variable {
 		LowKey:    p.LowKey,
 		Inclusion: p.Update,
 		Siblings:  p.OldSiblings,
+		KeyBits:   p.KeyBits,
 	}.Run(api)
 	return p.Mutate.NewRoot(api)
 }
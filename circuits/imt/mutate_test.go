@@ -0,0 +1,148 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+// testMutateCircuit is a Mutate without the verification of the old root.
+type testMutateCircuit struct {
+	Mutate  Mutate
+	NewRoot frontend.Variable
+}
+
+func (c *testMutateCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Mutate.NewRoot(api), c.NewRoot)
+	return nil
+}
+
+// foldTop returns the top hash of the path of a node, as the native tree
+// hashes it.
+func foldTop(t testing.TB, key, value, nextKey *big.Int, index uint64, siblings []*big.Int) *big.Int {
+	h, err := testHash([]*big.Int{key, value, nextKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for level := len(siblings) - 1; level >= 0; level, index = level-1, index/2 {
+		if siblings[level].Sign() == 0 {
+			continue
+		}
+		if index%2 == 0 {
+			h, err = testHash([]*big.Int{h, siblings[level]})
+		} else {
+			h, err = testHash([]*big.Int{siblings[level], h})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+func TestMutateCapacity(t *testing.T) {
+	const levels = 4
+	w := testWriter(t, levels)
+	if _, err := w.Insert(big.NewInt(10), big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an inclusion at an index beyond the size, through empty siblings
+	ip, err := w.ProveInclusion(big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewInclusionAssignment(ip, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewInclusionCircuit(levels), a); err != nil {
+		t.Fatal(err)
+	}
+	a.Index = 1 + 4
+	if err := isSolved(NewInclusionCircuit(levels), a); err == nil {
+		t.Fatal("inclusion beyond the size accepted")
+	}
+
+	// a root of a size beyond capacity
+	n := ip.Node()
+	top := foldTop(t, n.Key(), n.Value(), n.NextKey(), n.Index(), ip.Siblings())
+	for _, size := range []*big.Int{big.NewInt(1 << levels), new(big.Int).Sub(fr.Modulus(), big.NewInt(1))} {
+		root, err := testHash([]*big.Int{top, size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Index, a.Size, a.Root = 1, size, root
+		if err := isSolved(NewInclusionCircuit(levels), a); err == nil {
+			t.Fatalf("inclusion in a tree of size %s accepted", size)
+		}
+	}
+
+	// an update at an index beyond the size
+	mp, err := w.Update(big.NewInt(10), big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMutateAssignment(mp, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewMutateCircuit(levels), m); err != nil {
+		t.Fatal(err)
+	}
+	m.LowIndex = 1 + 8
+	if err := isSolved(NewMutateCircuit(levels), m); err == nil {
+		t.Fatal("update beyond the size accepted")
+	}
+	u, err := NewMutate(mp, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.LowIndex = 1 + 8
+	empty := &testMutateCircuit{Mutate: Mutate{Siblings: make([]frontend.Variable, levels), LowSiblings: make([]frontend.Variable, levels)}}
+	if err := isSolved(empty, &testMutateCircuit{Mutate: u, NewRoot: mp.NewRoot()}); err == nil {
+		t.Fatal("update beyond the size accepted without verification")
+	}
+
+	// an insert whose low node is the inserted node itself, at the new size,
+	// can only be rejected by bounding the low index by the old size
+	mp, err = w.Insert(big.NewInt(20), big.NewInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewMutate(mp, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.LowIndex = mp.OldSize() + 1
+	i.LowKey, i.LowValue, i.NextKey = i.Key, i.Value, i.Key
+	i.LowSiblings = i.Siblings
+	top = foldTop(t, big.NewInt(20), big.NewInt(3), big.NewInt(20), mp.OldSize()+1, mp.Siblings())
+	forged, err := testHash([]*big.Int{top, new(big.Int).SetUint64(mp.OldSize() + 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(empty, &testMutateCircuit{Mutate: i, NewRoot: forged}); err == nil {
+		t.Fatal("insert with the low node at the new size accepted")
+	}
+
+	// every insert up to capacity is accepted
+	for k := int64(30); ; k++ {
+		mp, err := w.Insert(big.NewInt(k), big.NewInt(k))
+		if err != nil {
+			break
+		}
+		m, err := NewMutateAssignment(mp, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewMutateCircuit(levels), m); err != nil {
+			t.Fatalf("insert of %d: %v", k, err)
+		}
+	}
+	if size, err := w.Size(); err != nil || size != 1<<levels-1 {
+		t.Fatalf("size = %d, %v", size, err)
+	}
+}
@@ -0,0 +1,117 @@
+package imt
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+// RangeExclusion proves that no key in [Start, End] exists, given the low node
+// of Start.
+type RangeExclusion struct {
+	Enabled    frontend.Variable
+	Root       frontend.Variable
+	Size       frontend.Variable
+	Start      frontend.Variable
+	End        frontend.Variable
+	Index      frontend.Variable
+	LowKey     frontend.Variable
+	LowValue   frontend.Variable
+	LowNextKey frontend.Variable
+	Siblings   []frontend.Variable
+	KeyBits    int
+}
+
+func (v RangeExclusion) Run(api frontend.API) {
+	Verify{
+		Enabled:   v.Enabled,
+		Root:      v.Root,
+		Size:      v.Size,
+		Key:       v.Start,
+		Value:     v.LowValue,
+		Index:     v.Index,
+		NextKey:   v.LowNextKey,
+		LowKey:    v.LowKey,
+		Siblings:  v.Siblings,
+		Inclusion: 0,
+		KeyBits:   v.KeyBits,
+	}.Run(api)
+
+	assertDifferentIfEnabled(api, v.End, v.LowNextKey, v.Enabled) // end != nextKey
+	nextKeyBound := nextKeyBound(api, v.LowNextKey, v.KeyBits)
+	assertOrderedIfEnabled(api, v.Enabled, v.KeyBits, v.Start, v.End, nextKeyBound) // start <= end <= nextKey
+}
+
+type RangeExclusionCircuit struct {
+	Root                                      frontend.Variable `gnark:",public"`
+	Start                                     frontend.Variable `gnark:",public"`
+	End                                       frontend.Variable `gnark:",public"`
+	Size, Index, LowKey, LowValue, LowNextKey frontend.Variable
+	Siblings                                  []frontend.Variable
+	KeyBits                                   int
+}
+
+func NewRangeExclusionCircuit(levels int) *RangeExclusionCircuit {
+	return &RangeExclusionCircuit{Siblings: make([]frontend.Variable, levels)}
+}
+
+func NewRangeExclusionAssignment(p native.Proof, start, end *big.Int, levels int) (*RangeExclusionCircuit, error) {
+	v, err := NewRangeExclusion(p, start, end, levels)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeExclusionCircuit{
+		Root:       v.Root,
+		Start:      v.Start,
+		End:        v.End,
+		Size:       v.Size,
+		Index:      v.Index,
+		LowKey:     v.LowKey,
+		LowValue:   v.LowValue,
+		LowNextKey: v.LowNextKey,
+		Siblings:   v.Siblings,
+	}, nil
+}
+
+func (c *RangeExclusionCircuit) Define(api frontend.API) error {
+	RangeExclusion{
+		Enabled:    1,
+		Root:       c.Root,
+		Size:       c.Size,
+		Start:      c.Start,
+		End:        c.End,
+		Index:      c.Index,
+		LowKey:     c.LowKey,
+		LowValue:   c.LowValue,
+		LowNextKey: c.LowNextKey,
+		Siblings:   c.Siblings,
+		KeyBits:    c.KeyBits,
+	}.Run(api)
+	return nil
+}
+
+// NewRangeExclusion returns the assignment of a RangeExclusion from a proof
+// returned by ProveRangeExclusion.
+func NewRangeExclusion(p native.Proof, start, end *big.Int, levels int) (RangeExclusion, error) {
+	e, err := NewExclusion(p, start, levels)
+	if err != nil {
+		return RangeExclusion{}, err
+	}
+	if start.Cmp(end) > 0 || !excludes(p.Node(), end) {
+		return RangeExclusion{}, errors.New("proof does not exclude the range")
+	}
+	return RangeExclusion{
+		Enabled:    1,
+		Root:       e.Root,
+		Size:       e.Size,
+		Start:      start,
+		End:        end,
+		Index:      e.Index,
+		LowKey:     e.LowKey,
+		LowValue:   e.LowValue,
+		LowNextKey: e.LowNextKey,
+		Siblings:   e.Siblings,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRangeExclusion(t *testing.T) {
+	const levels = 5
+	w := testWriter(t, levels)
+	for _, k := range []int64{10, 20, 30} {
+		if _, err := w.Insert(big.NewInt(k), big.NewInt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, r := range [][2]int64{{0, 5}, {5, 4}, {5, 10}, {10, 15}, {11, 25}} {
+		if _, err := w.ProveRangeExclusion(big.NewInt(r[0]), big.NewInt(r[1])); err == nil {
+			t.Fatalf("expected range %v to fail", r)
+		}
+	}
+	for _, r := range [][2]int64{{1, 9}, {11, 19}, {15, 15}, {31, 1 << 40}} {
+		start, end := big.NewInt(r[0]), big.NewInt(r[1])
+		p, err := w.ProveRangeExclusion(start, end)
+		if err != nil {
+			t.Fatal(r, err)
+		}
+		a, err := NewRangeExclusionAssignment(p, start, end, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewRangeExclusionCircuit(levels), a); err != nil {
+			t.Fatal(r, err)
+		}
+	}
+
+	p, err := w.ProveRangeExclusion(big.NewInt(11), big.NewInt(19))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRangeExclusionAssignment(p, big.NewInt(11), big.NewInt(20), levels); err == nil {
+		t.Fatal("expected range including the next key to fail")
+	}
+	a, err := NewRangeExclusionAssignment(p, big.NewInt(11), big.NewInt(19), levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, end := range []int64{20, 25} {
+		a.End = end
+		if err := isSolved(NewRangeExclusionCircuit(levels), a); err == nil {
+			t.Fatalf("range ending at %d accepted", end)
+		}
+	}
+}
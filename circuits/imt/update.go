@@ -21,6 +21,7 @@ func (p Update) NewRoot(api frontend.API) frontend.Variable {
 type UpdateWithVerify struct {
 	Update
 	OldValue frontend.Variable
+	KeyBits  int
 }
 
 func (p UpdateWithVerify) NewRoot(api frontend.API) frontend.Variable {
@@ -35,6 +36,7 @@ func (p UpdateWithVerify) NewRoot(api frontend.API) frontend.Variable {
 		LowKey:    p.Key,
 		Inclusion: 1,
 		Siblings:  p.Siblings,
+		KeyBits:   p.KeyBits,
 	}.Run(api)
 	return p.Update.NewRoot(api)
 }
@@ -1,6 +1,8 @@
 package imt
 
 import (
+	"math/big"
+
 	"github.com/consensys/gnark/frontend"
 	"github.com/mdehoog/poseidon/circuits/poseidon"
 )
@@ -13,6 +15,40 @@ func assertDifferentIfEnabled(api frontend.API, a, b, enabled frontend.Variable)
 	api.AssertIsEqual(api.Mul(enabled, api.IsZero(api.Sub(a, b))), 0)
 }
 
+// assertOrderedIfEnabled asserts that the values are in non-decreasing order.
+// If bits is zero they are compared over the whole field, otherwise they must
+// fit in bits bits, and they and their differences are decomposed into bits.
+func assertOrderedIfEnabled(api frontend.API, enabled frontend.Variable, bits int, values ...frontend.Variable) {
+	if bits == 0 {
+		for i := 1; i < len(values); i++ {
+			api.AssertIsLessOrEqual(api.Mul(enabled, values[i-1]), values[i])
+		}
+		return
+	}
+	// a wrapped negative difference must not fit in bits bits
+	if bits < 0 || bits > api.Compiler().FieldBitLen()-2 {
+		panic("invalid key bits")
+	}
+	for i, v := range values {
+		api.ToBinary(api.Mul(enabled, v), bits)
+		if i > 0 {
+			api.ToBinary(api.Mul(enabled, api.Sub(v, values[i-1])), bits)
+		}
+	}
+}
+
+// nextKeyBound returns the greatest key a node's key can be less than or equal
+// to: its next key, which must be excluded separately, or if there is none the
+// greatest key.
+func nextKeyBound(api frontend.API, nextKey frontend.Variable, bits int) frontend.Variable {
+	if bits == 0 {
+		return api.Sub(nextKey, api.IsZero(nextKey)) // nextKey == 0 ? nextKey - 1 : nextKey
+	}
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	max.Sub(max, big.NewInt(1))
+	return api.Select(api.IsZero(nextKey), max, nextKey)
+}
+
 func hashSwitcher(api frontend.API, indexBit, hash, sibling frontend.Variable) frontend.Variable {
 	l := api.Select(indexBit, sibling, hash)
 	r := api.Select(indexBit, hash, sibling)
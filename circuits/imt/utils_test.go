@@ -0,0 +1,100 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+func TestKeyBits(t *testing.T) {
+	const levels = 5
+	w := testWriter(t, levels)
+	for _, k := range []int64{10, 20, 30} {
+		if _, err := w.Insert(big.NewInt(k), big.NewInt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mp, err := w.Insert(big.NewInt(25), big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bits := range []int{0, 16, 160, 248} {
+		ip, err := w.ProveInclusion(big.NewInt(20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ia, err := NewInclusionAssignment(ip, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ic := NewInclusionCircuit(levels)
+		ic.KeyBits = bits
+		if err := isSolved(ic, ia); err != nil {
+			t.Fatalf("%d bits: inclusion: %v", bits, err)
+		}
+
+		ep, err := w.ProveExclusion(big.NewInt(15))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ea, err := NewExclusionAssignment(ep, big.NewInt(15), levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec := NewExclusionCircuit(levels)
+		ec.KeyBits = bits
+		if err := isSolved(ec, ea); err != nil {
+			t.Fatalf("%d bits: exclusion: %v", bits, err)
+		}
+		for _, key := range []int64{22, 5} { // past the low node's next key, before the low node
+			ea.Key = key
+			if err := isSolved(ec, ea); err == nil {
+				t.Fatalf("%d bits: exclusion of %d accepted", bits, key)
+			}
+		}
+
+		ma, err := NewMutateAssignment(mp, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc := NewMutateCircuit(levels)
+		mc.KeyBits = bits
+		if err := isSolved(mc, ma); err != nil {
+			t.Fatalf("%d bits: mutate: %v", bits, err)
+		}
+
+		// an end key beyond the key width cannot be compared
+		for _, r := range []struct {
+			start, end int64
+			ok         bool
+		}{
+			{31, 1 << 10, true},
+			{31, 1 << 40, bits != 16},
+			{11, 19, true},
+		} {
+			rp, err := w.ProveRangeExclusion(big.NewInt(r.start), big.NewInt(r.end))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ra, err := NewRangeExclusionAssignment(rp, big.NewInt(r.start), big.NewInt(r.end), levels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc := NewRangeExclusionCircuit(levels)
+			rc.KeyBits = bits
+			if err := isSolved(rc, ra); (err == nil) != r.ok {
+				t.Fatalf("%d bits: range [%d, %d): %v", bits, r.start, r.end, err)
+			}
+		}
+	}
+
+	ic := NewInclusionCircuit(levels)
+	ic.KeyBits = 253
+	if _, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, ic); err == nil {
+		t.Fatal("expected an error for a key width of the full field")
+	}
+}
@@ -16,6 +16,7 @@ type Verify struct {
 	LowKey    frontend.Variable // inclusion: use Key
 	Siblings  []frontend.Variable
 	Inclusion frontend.Variable
+	KeyBits   int // if set, keys must fit in KeyBits bits, making comparisons cheaper
 }
 
 func (v Verify) Run(api frontend.API) {
@@ -23,9 +24,18 @@ func (v Verify) Run(api frontend.API) {
 	assertEqualIfEnabled(api, prevKeyEqualsKey, v.Inclusion, v.Enabled) // inclusion ? key == lowKey : key != lowKey
 	assertDifferentIfEnabled(api, v.Key, v.NextKey, v.Enabled)          // key != nextKey
 
-	api.AssertIsLessOrEqual(api.Mul(v.Enabled, v.LowKey), v.Key)        // lowKey <= key
-	nextKeyOverflow := api.Sub(v.NextKey, api.IsZero(v.NextKey))        // nextKey == 0 ? nextKey - 1 : nextKey
-	api.AssertIsLessOrEqual(api.Mul(v.Enabled, v.Key), nextKeyOverflow) // key <= nextKey
+	nextKeyBound := nextKeyBound(api, v.NextKey, v.KeyBits)
+	assertOrderedIfEnabled(api, v.Enabled, v.KeyBits, v.LowKey, v.Key, nextKeyBound) // lowKey <= key <= nextKey
+
+	v.verifyPath(api)
+}
+
+// verifyPath verifies the path of the low node to the root, without checking
+// the keys.
+func (v Verify) verifyPath(api frontend.API) {
+	// the tree is within capacity, and the node is one of its size + 1 nodes
+	api.ToBinary(api.Mul(v.Enabled, v.Size), len(v.Siblings))                   // size < 2^levels
+	api.ToBinary(api.Mul(v.Enabled, api.Sub(v.Size, v.Index)), len(v.Siblings)) // index <= size
 
 	indexBits := api.ToBinary(v.Index, len(v.Siblings))
 	h := poseidon.Hash(api, []frontend.Variable{v.LowKey, v.Value, v.NextKey})
@@ -0,0 +1,219 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+)
+
+// Siblings converts native siblings, ordered from the top level down, into
+// the siblings of a circuit with the given number of levels. Trees with fewer
+// levels are padded with empty top levels, which hash to the same root.
+func Siblings(siblings []*big.Int, levels int) ([]frontend.Variable, error) {
+	if len(siblings) > levels {
+		return nil, fmt.Errorf("proof has %d levels, circuit has %d", len(siblings), levels)
+	}
+	v := make([]frontend.Variable, levels)
+	padding := levels - len(siblings)
+	for i := 0; i < padding; i++ {
+		v[i] = 0
+	}
+	for i, s := range siblings {
+		v[padding+i] = s
+	}
+	return v, nil
+}
+
+func NewInclusion(p native.Proof, levels int) (Inclusion, error) {
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return Inclusion{}, err
+	}
+	n := p.Node()
+	return Inclusion{
+		Enabled:  1,
+		Root:     p.Root(),
+		Size:     p.Size(),
+		Key:      n.Key(),
+		Value:    n.Value(),
+		Index:    n.Index(),
+		NextKey:  n.NextKey(),
+		Siblings: siblings,
+	}, nil
+}
+
+// NewExclusion returns the assignment of an Exclusion of key, which p must
+// prove the low node of.
+func NewExclusion(p native.Proof, key *big.Int, levels int) (Exclusion, error) {
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return Exclusion{}, err
+	}
+	n := p.Node()
+	if !excludes(n, key) {
+		return Exclusion{}, errors.New("proof is not of the low node of key")
+	}
+	return Exclusion{
+		Enabled:    1,
+		Root:       p.Root(),
+		Size:       p.Size(),
+		Key:        key,
+		Index:      n.Index(),
+		LowKey:     n.Key(),
+		LowValue:   n.Value(),
+		LowNextKey: n.NextKey(),
+		Siblings:   siblings,
+	}, nil
+}
+
+// NewVerify returns the assignment of a Verify of key, proving inclusion if p
+// is of key's node, and exclusion if p is of its low node.
+func NewVerify(p native.Proof, key *big.Int, levels int) (Verify, error) {
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return Verify{}, err
+	}
+	n := p.Node()
+	inclusion := n.Key().Cmp(key) == 0
+	if !inclusion && !excludes(n, key) {
+		return Verify{}, errors.New("proof is not of the node or low node of key")
+	}
+	v := Verify{
+		Enabled:   1,
+		Root:      p.Root(),
+		Size:      p.Size(),
+		Key:       key,
+		Value:     n.Value(),
+		Index:     n.Index(),
+		NextKey:   n.NextKey(),
+		LowKey:    n.Key(),
+		Siblings:  siblings,
+		Inclusion: 0,
+	}
+	if inclusion {
+		v.Inclusion = 1
+	}
+	return v, nil
+}
+
+func excludes(lowNode native.Node, key *big.Int) bool {
+	nextKey := lowNode.NextKey()
+	return lowNode.Key().Cmp(key) < 0 && (nextKey.Sign() == 0 || key.Cmp(nextKey) < 0)
+}
+
+func NewInsert(p native.MutateProof, levels int) (Insert, error) {
+	if p.Update() {
+		return Insert{}, errors.New("proof is of an update")
+	}
+	m, err := NewMutate(p, levels)
+	if err != nil {
+		return Insert{}, err
+	}
+	return Insert{
+		Enabled:     m.Enabled,
+		OldSize:     m.OldSize,
+		OldRoot:     m.OldRoot,
+		Key:         m.Key,
+		Value:       m.Value,
+		NextKey:     m.NextKey,
+		Siblings:    m.Siblings,
+		LowKey:      m.LowKey,
+		LowValue:    m.LowValue,
+		LowIndex:    m.LowIndex,
+		LowSiblings: m.LowSiblings,
+	}, nil
+}
+
+func NewInsertWithVerify(p native.MutateProof, levels int) (InsertWithVerify, error) {
+	insert, err := NewInsert(p, levels)
+	if err != nil {
+		return InsertWithVerify{}, err
+	}
+	oldSiblings, err := Siblings(p.OldSiblings(), levels)
+	if err != nil {
+		return InsertWithVerify{}, err
+	}
+	return InsertWithVerify{
+		Insert:      insert,
+		OldSiblings: oldSiblings,
+	}, nil
+}
+
+func NewUpdate(p native.MutateProof, levels int) (Update, error) {
+	if !p.Update() {
+		return Update{}, errors.New("proof is of an insert")
+	}
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return Update{}, err
+	}
+	n := p.Node()
+	return Update{
+		Enabled:  1,
+		Size:     p.OldSize(),
+		OldRoot:  p.OldRoot(),
+		Key:      n.Key(),
+		Value:    n.Value(),
+		NextKey:  n.NextKey(),
+		Index:    n.Index(),
+		Siblings: siblings,
+	}, nil
+}
+
+func NewUpdateWithVerify(p native.MutateProof, levels int) (UpdateWithVerify, error) {
+	update, err := NewUpdate(p, levels)
+	if err != nil {
+		return UpdateWithVerify{}, err
+	}
+	return UpdateWithVerify{
+		Update:   update,
+		OldValue: p.LowNode().Value(),
+	}, nil
+}
+
+// NewMutate returns the assignment of a Mutate. For updates, the low node
+// fields are those of the node before the update.
+func NewMutate(p native.MutateProof, levels int) (Mutate, error) {
+	siblings, err := Siblings(p.Siblings(), levels)
+	if err != nil {
+		return Mutate{}, err
+	}
+	lowSiblings, err := Siblings(p.LowSiblings(), levels)
+	if err != nil {
+		return Mutate{}, err
+	}
+	n := p.Node()
+	lowNode := p.LowNode()
+	return Mutate{
+		Enabled:     1,
+		OldSize:     p.OldSize(),
+		OldRoot:     p.OldRoot(),
+		Key:         n.Key(),
+		Value:       n.Value(),
+		NextKey:     n.NextKey(),
+		Siblings:    siblings,
+		LowKey:      lowNode.Key(),
+		LowValue:    lowNode.Value(),
+		LowIndex:    lowNode.Index(),
+		LowSiblings: lowSiblings,
+		Update:      p.UpdateVariable(),
+	}, nil
+}
+
+func NewMutateWithVerify(p native.MutateProof, levels int) (MutateWithVerify, error) {
+	mutate, err := NewMutate(p, levels)
+	if err != nil {
+		return MutateWithVerify{}, err
+	}
+	oldSiblings, err := Siblings(p.OldSiblings(), levels)
+	if err != nil {
+		return MutateWithVerify{}, err
+	}
+	return MutateWithVerify{
+		Mutate:      mutate,
+		OldSiblings: oldSiblings,
+	}, nil
+}
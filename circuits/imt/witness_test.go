@@ -0,0 +1,124 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/mdehoog/indexed-merkle-tree/db"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+	"github.com/mdehoog/poseidon/poseidon"
+)
+
+var testHash native.HashFn = poseidon.Hash[*fr.Element]
+
+func testDB(t testing.TB) db.Database {
+	p, err := pebble.Open(t.TempDir(), &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := db.NewPebble(p)
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
+func testWriter(t testing.TB, levels uint64) native.TreeWriter {
+	return native.NewTreeWriter(testDB(t).NewTransaction(), levels, fr.Bytes, testHash)
+}
+
+func isSolved(circuit, assignment frontend.Circuit) error {
+	return test.IsSolved(circuit, assignment, ecc.BN254.ScalarField())
+}
+
+func TestWitness(t *testing.T) {
+	// a tree with fewer levels than the circuits, whose proofs are padded
+	const treeLevels, levels = 6, 8
+	w := testWriter(t, treeLevels)
+	for _, kv := range [][2]int64{{10, 1}, {30, 2}, {20, 3}, {10, 4}, {5, 5}, {30, 6}} {
+		mp, err := w.Set(big.NewInt(kv[0]), big.NewInt(kv[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewMutateAssignment(mp, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewMutateCircuit(levels), m); err != nil {
+			t.Fatalf("mutate %v: %v", kv, err)
+		}
+		if mp.Update() {
+			u, err := NewUpdateAssignment(mp, levels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := isSolved(NewUpdateCircuit(levels), u); err != nil {
+				t.Fatalf("update %v: %v", kv, err)
+			}
+			if _, err := NewInsert(mp, levels); err == nil {
+				t.Fatal("expected insert witness of an update to fail")
+			}
+		} else {
+			i, err := NewInsertAssignment(mp, levels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := isSolved(NewInsertCircuit(levels), i); err != nil {
+				t.Fatalf("insert %v: %v", kv, err)
+			}
+			if _, err := NewUpdate(mp, levels); err == nil {
+				t.Fatal("expected update witness of an insert to fail")
+			}
+		}
+	}
+
+	ip, err := w.ProveInclusion(big.NewInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc, err := NewInclusionAssignment(ip, levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewInclusionCircuit(levels), inc); err != nil {
+		t.Fatal(err)
+	}
+	inc.Value = 4
+	if err := isSolved(NewInclusionCircuit(levels), inc); err == nil {
+		t.Fatal("inclusion of the wrong value accepted")
+	}
+	if _, err := NewInclusion(ip, treeLevels-1); err == nil {
+		t.Fatal("expected proof with more levels than the circuit to fail")
+	}
+
+	ep, err := w.ProveExclusion(big.NewInt(25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := NewExclusionAssignment(ep, big.NewInt(25), levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := isSolved(NewExclusionCircuit(levels), exc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewExclusion(ep, big.NewInt(35), levels); err == nil {
+		t.Fatal("expected exclusion of a key beyond the low node's next key to fail")
+	}
+
+	for _, v := range []struct {
+		p   native.Proof
+		key int64
+	}{{ip, 20}, {ep, 25}} {
+		a, err := NewVerifyAssignment(v.p, big.NewInt(v.key), levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := isSolved(NewVerifyCircuit(levels), a); err != nil {
+			t.Fatalf("verify %d: %v", v.key, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package prover
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+// Backend is a proving system, and the constraint system it compiles to.
+type Backend int
+
+const (
+	Groth16 Backend = iota // R1CS
+	PLONK                  // SCS, with the KZG SRS given WithSRS
+)
+
+func (b Backend) String() string {
+	switch b {
+	case Groth16:
+		return "groth16"
+	case PLONK:
+		return "plonk"
+	}
+	return fmt.Sprintf("Backend(%d)", int(b))
+}
+
+// Key is a proving or verifying key of either backend.
+type Key interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// Proof is a proof of either backend.
+type Proof interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// Compile compiles the circuit to the constraint system of the backend.
+func Compile(circuit frontend.Circuit, backend Backend) (constraint.ConstraintSystem, error) {
+	switch backend {
+	case Groth16:
+		return frontend.Compile(curve.ScalarField(), r1cs.NewBuilder, circuit)
+	case PLONK:
+		return frontend.Compile(curve.ScalarField(), scs.NewBuilder, circuit)
+	}
+	return nil, fmt.Errorf("unknown backend %s", backend)
+}
+
+// setup runs the setup of the backend. Without an SRS, PLONK generates one
+// with unsafekzg, whose toxic waste is known, so that is for testing only.
+func (b Backend) setup(ccs constraint.ConstraintSystem, srs, srsLagrange kzg.SRS) (Key, Key, error) {
+	switch b {
+	case Groth16:
+		return groth16.Setup(ccs)
+	case PLONK:
+		if srs == nil {
+			var err error
+			srs, srsLagrange, err = unsafekzg.NewSRS(ccs)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return plonk.Setup(ccs, srs, srsLagrange)
+	}
+	return nil, nil, fmt.Errorf("unknown backend %s", b)
+}
+
+func (b Backend) newKeys() (Key, Key) {
+	if b == PLONK {
+		return plonk.NewProvingKey(curve), plonk.NewVerifyingKey(curve)
+	}
+	return groth16.NewProvingKey(curve), groth16.NewVerifyingKey(curve)
+}
+
+func (b Backend) newProof() Proof {
+	if b == PLONK {
+		return plonk.NewProof(curve)
+	}
+	return groth16.NewProof(curve)
+}
+
+func (b Backend) prove(ccs constraint.ConstraintSystem, pk Key, w witness.Witness) (Proof, error) {
+	if b == PLONK {
+		return plonk.Prove(ccs, pk.(plonk.ProvingKey), w)
+	}
+	return groth16.Prove(ccs, pk.(groth16.ProvingKey), w)
+}
+
+func (b Backend) verify(proof Proof, vk Key, w witness.Witness) error {
+	if b == PLONK {
+		p, ok := proof.(plonk.Proof)
+		if !ok {
+			return fmt.Errorf("not a %s proof", b)
+		}
+		return plonk.Verify(p, vk.(plonk.VerifyingKey), w)
+	}
+	p, ok := proof.(groth16.Proof)
+	if !ok {
+		return fmt.Errorf("not a %s proof", b)
+	}
+	return groth16.Verify(p, vk.(groth16.VerifyingKey), w)
+}
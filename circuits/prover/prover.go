@@ -0,0 +1,194 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+)
+
+const curve = ecc.BN254
+
+// Prover proves and verifies a single circuit, with Groth16 unless configured
+// otherwise.
+//
+// Groth16 keys are generated by a single-party setup, whose randomness is
+// discarded but never provably so: a prover holding it could forge proofs. Use
+// a key from a trusted setup ceremony in production. PLONK keys are derived
+// from the SRS given WithSRS, e.g. from a ceremony; without one, an unsafe SRS
+// is generated for testing.
+type Prover struct {
+	backend Backend
+	ccs     constraint.ConstraintSystem
+	pk      Key
+	vk      Key
+}
+
+type options struct {
+	backend     Backend
+	srs         kzg.SRS
+	srsLagrange kzg.SRS
+}
+
+type Option func(*options)
+
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithSRS sets the KZG SRS of the PLONK setup, in canonical and Lagrange form,
+// both large enough for the circuit. A canonical SRS from a ceremony can be
+// converted to Lagrange form with gnark-crypto's ToLagrangeG1.
+func WithSRS(srs, srsLagrange kzg.SRS) Option {
+	return func(o *options) {
+		o.srs = srs
+		o.srsLagrange = srsLagrange
+	}
+}
+
+// New compiles the circuit and runs its setup. If dir is not empty, the keys
+// are loaded from dir if a previous setup of the same constraint system is
+// stored there, and stored there otherwise.
+func New(circuit frontend.Circuit, dir string, opts ...Option) (*Prover, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ccs, err := Compile(circuit, o.backend)
+	if err != nil {
+		return nil, err
+	}
+	if (o.srs == nil) != (o.srsLagrange == nil) {
+		return nil, errors.New("both forms of the SRS must be given")
+	}
+	p := &Prover{backend: o.backend, ccs: ccs}
+	if dir == "" {
+		p.pk, p.vk, err = p.backend.setup(ccs, o.srs, o.srsLagrange)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	id, err := circuitID(ccs, o.srs)
+	if err != nil {
+		return nil, err
+	}
+	pkPath := filepath.Join(dir, id+".pk")
+	vkPath := filepath.Join(dir, id+".vk")
+	p.pk, p.vk = p.backend.newKeys()
+	err = readFile(pkPath, p.pk)
+	if err == nil {
+		err = readFile(vkPath, p.vk)
+	}
+	if err == nil {
+		return p, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	p.pk, p.vk, err = p.backend.setup(ccs, o.srs, o.srsLagrange)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	// write the verifying key last, as its presence marks a complete setup
+	if err := writeFile(pkPath, p.pk); err != nil {
+		return nil, err
+	}
+	if err := writeFile(vkPath, p.vk); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// circuitID identifies a constraint system, and the SRS if any, by the hash of
+// their serialization, so keys of an unsafe SRS are never loaded for another.
+func circuitID(ccs constraint.ConstraintSystem, srs kzg.SRS) (string, error) {
+	h := sha256.New()
+	if _, err := ccs.WriteTo(h); err != nil {
+		return "", err
+	}
+	if srs != nil {
+		if _, err := srs.WriteTo(h); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readFile(path string, r io.ReaderFrom) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = r.ReadFrom(f)
+	return err
+}
+
+// writeFile writes to a temporary file first, so a partially written key is
+// never read.
+func writeFile(path string, w io.WriterTo) error {
+	var b bytes.Buffer
+	if _, err := w.WriteTo(&b); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p *Prover) Backend() Backend {
+	return p.backend
+}
+
+func (p *Prover) ConstraintSystem() constraint.ConstraintSystem {
+	return p.ccs
+}
+
+func (p *Prover) ProvingKey() Key {
+	return p.pk
+}
+
+func (p *Prover) VerifyingKey() Key {
+	return p.vk
+}
+
+// NewProof returns an empty proof to read a serialized proof into.
+func (p *Prover) NewProof() Proof {
+	return p.backend.newProof()
+}
+
+// Prove proves the assignment, which must be of the compiled circuit's type.
+func (p *Prover) Prove(assignment frontend.Circuit) (Proof, error) {
+	w, err := frontend.NewWitness(assignment, curve.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	return p.backend.prove(p.ccs, p.pk, w)
+}
+
+// Verify verifies the proof against the public inputs of the assignment. Only
+// the public fields of the assignment need to be set.
+func (p *Prover) Verify(proof Proof, assignment frontend.Circuit) error {
+	w, err := frontend.NewWitness(assignment, curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	return p.backend.verify(proof, p.vk, w)
+}
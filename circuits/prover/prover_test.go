@@ -0,0 +1,176 @@
+package prover
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+	circuits "github.com/mdehoog/indexed-merkle-tree/circuits/imt"
+	"github.com/mdehoog/indexed-merkle-tree/db"
+	native "github.com/mdehoog/indexed-merkle-tree/imt"
+	"github.com/mdehoog/poseidon/poseidon"
+)
+
+const testLevels = 4
+
+var testHash native.HashFn = poseidon.Hash[*fr.Element]
+
+// testTree returns a tree with two inserts and an update, and their proofs.
+func testTree(t testing.TB) (native.TreeWriter, []native.MutateProof) {
+	p, err := pebble.Open(t.TempDir(), &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := db.NewPebble(p)
+	t.Cleanup(func() { _ = d.Close() })
+	w := native.NewTreeWriter(d.NewTransaction(), testLevels, fr.Bytes, testHash)
+	var proofs []native.MutateProof
+	for _, kv := range [][2]int64{{10, 1}, {30, 2}, {10, 3}} {
+		mp, err := w.Set(big.NewInt(kv[0]), big.NewInt(kv[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, mp)
+	}
+	return w, proofs
+}
+
+func TestProver(t *testing.T) {
+	w, proofs := testTree(t)
+	dir := t.TempDir()
+	p, err := New(circuits.NewMutateCircuit(testLevels), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d key files, want 2", len(files))
+	}
+	// loaded from dir
+	cached, err := New(circuits.NewMutateCircuit(testLevels), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mp := range proofs {
+		a, err := circuits.NewMutateAssignment(mp, testLevels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := p.Prove(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cached.Verify(proof, &circuits.MutateCircuit{OldRoot: a.OldRoot, NewRoot: a.NewRoot, Key: a.Key}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cached.Verify(proof, &circuits.MutateCircuit{OldRoot: a.OldRoot, NewRoot: 5, Key: a.Key}); err == nil {
+			t.Fatal("proof verified against the wrong root")
+		}
+	}
+
+	ep, err := w.ProveExclusion(big.NewInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = New(circuits.NewExclusionCircuit(testLevels), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := circuits.NewExclusionAssignment(ep, big.NewInt(20), testLevels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := p.Prove(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(proof, a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, c := range []frontend.Circuit{
+		circuits.NewInclusionCircuit(testLevels),
+		circuits.NewExclusionCircuit(testLevels),
+		circuits.NewMutateCircuit(testLevels),
+		circuits.NewBatchMutateCircuit(2, testLevels),
+		circuits.NewBatchExclusionCircuit(2, 2, testLevels),
+		circuits.NewRangeExclusionCircuit(testLevels),
+		circuits.NewCompressedBatchMutateCircuit(2, testLevels),
+	} {
+		for _, backend := range []Backend{Groth16, PLONK} {
+			if _, err := Compile(c, backend); err != nil {
+				t.Errorf("%T with %s: %v", c, backend, err)
+			}
+		}
+	}
+}
+
+func TestPLONK(t *testing.T) {
+	_, proofs := testTree(t)
+	ccs, err := Compile(circuits.NewMutateCircuit(testLevels), PLONK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srs, srsLagrange, err := unsafekzg.NewSRS(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	p, err := New(circuits.NewMutateCircuit(testLevels), dir, WithBackend(PLONK), WithSRS(srs, srsLagrange))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keys of the given SRS are stored apart from those of a generated one
+	_, err = New(circuits.NewMutateCircuit(testLevels), dir, WithBackend(PLONK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("got %d key files, want 4", len(files))
+	}
+
+	for _, mp := range proofs {
+		a, err := circuits.NewMutateAssignment(mp, testLevels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := p.Prove(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if _, err := proof.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		read := p.NewProof()
+		if _, err := read.ReadFrom(&b); err != nil {
+			t.Fatal(err)
+		}
+		public := &circuits.MutateCircuit{OldRoot: a.OldRoot, NewRoot: a.NewRoot, Key: a.Key}
+		if err := p.Verify(read, public); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Verify(read, &circuits.MutateCircuit{OldRoot: a.OldRoot, NewRoot: 5, Key: a.Key}); err == nil {
+			t.Fatal("proof verified against the wrong root")
+		}
+	}
+
+	if _, err := New(circuits.NewMutateCircuit(testLevels), "", WithBackend(PLONK), WithSRS(srs, nil)); err == nil {
+		t.Fatal("expected an error without the Lagrange SRS")
+	}
+}
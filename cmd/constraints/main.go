@@ -0,0 +1,142 @@
+// Command constraints prints the number of constraints of each circuit in
+// circuits/imt, for each tree depth and backend.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/logger"
+	"github.com/mdehoog/indexed-merkle-tree/circuits/imt"
+	"github.com/mdehoog/indexed-merkle-tree/circuits/prover"
+)
+
+type circuit struct {
+	name string
+	new  func(levels int) frontend.Circuit
+}
+
+func main() {
+	levelsFlag := flag.String("levels", "8,16,32,64", "comma-separated tree depths")
+	batch := flag.Int("batch", 16, "number of mutations or keys in batched circuits")
+	lowNodes := flag.Int("low-nodes", 4, "number of low nodes in batched exclusion circuits")
+	keyBits := flag.Int("key-bits", 0, "bit length of keys, or 0 for full field elements")
+	historyLevels := flag.Int("history-levels", 16, "depth of the root history in historical circuits")
+	flag.Parse()
+
+	var depths []int
+	for _, s := range strings.Split(*levelsFlag, ",") {
+		levels, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			log.Fatalf("invalid levels %q", s)
+		}
+		depths = append(depths, levels)
+	}
+
+	circuits := []circuit{
+		{"inclusion", func(l int) frontend.Circuit {
+			c := imt.NewInclusionCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"exclusion", func(l int) frontend.Circuit {
+			c := imt.NewExclusionCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"verify", func(l int) frontend.Circuit {
+			c := imt.NewVerifyCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"range-exclusion", func(l int) frontend.Circuit {
+			c := imt.NewRangeExclusionCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"insert", func(l int) frontend.Circuit {
+			c := imt.NewInsertCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"update", func(l int) frontend.Circuit {
+			c := imt.NewUpdateCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"mutate", func(l int) frontend.Circuit {
+			c := imt.NewMutateCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("batch-mutate/%d", *batch), func(l int) frontend.Circuit {
+			c := imt.NewBatchMutateCircuit(*batch, l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("batch-exclusion/%d/%d", *batch, *lowNodes), func(l int) frontend.Circuit {
+			c := imt.NewBatchExclusionCircuit(*batch, *lowNodes, l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"inserted-between", func(l int) frontend.Circuit {
+			c := imt.NewInsertedBetweenCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("historical-exclusion/%d", *historyLevels), func(l int) frontend.Circuit {
+			c := imt.NewHistoricalExclusionCircuit(l, *historyLevels)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("historical-inserted-between/%d", *historyLevels), func(l int) frontend.Circuit {
+			c := imt.NewHistoricalInsertedBetweenCircuit(l, *historyLevels)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{"compressed-mutate", func(l int) frontend.Circuit {
+			c := imt.NewCompressedMutateCircuit(l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("compressed-batch-mutate/%d", *batch), func(l int) frontend.Circuit {
+			c := imt.NewCompressedBatchMutateCircuit(*batch, l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+		{fmt.Sprintf("compressed-batch-exclusion/%d/%d", *batch, *lowNodes), func(l int) frontend.Circuit {
+			c := imt.NewCompressedBatchExclusionCircuit(*batch, *lowNodes, l)
+			c.KeyBits = *keyBits
+			return c
+		}},
+	}
+	backends := []prover.Backend{prover.Groth16, prover.PLONK}
+
+	logger.Disable()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "circuit\tlevels\t")
+	for _, b := range backends {
+		fmt.Fprintf(w, "%s\t", b)
+	}
+	fmt.Fprintln(w)
+	for _, c := range circuits {
+		for _, levels := range depths {
+			fmt.Fprintf(w, "%s\t%d\t", c.name, levels)
+			for _, b := range backends {
+				ccs, err := prover.Compile(c.new(levels), b)
+				if err != nil {
+					log.Fatalf("%s/%d/%s: %v", c.name, levels, b, err)
+				}
+				fmt.Fprintf(w, "%d\t", ccs.GetNbConstraints())
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	_ = w.Flush()
+}
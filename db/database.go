@@ -1,6 +1,9 @@
 package db
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+)
 
 var ErrNotFound = errors.New("not found")
 
@@ -26,3 +29,53 @@ type Transaction interface {
 	Discard()
 	Apply(Transaction) error
 }
+
+// CommitHooks is implemented by transactions that run callbacks when they are
+// committed, so that state derived from a transaction is finalised however it
+// is committed.
+type CommitHooks interface {
+	// BeforeCommit registers fn to be called before the transaction is
+	// committed. If fn returns an error, the transaction is not committed.
+	BeforeCommit(fn func() error)
+
+	// AfterCommit registers fn to be called once the transaction is
+	// committed.
+	AfterCommit(fn func())
+}
+
+// RangeDeleter is implemented by transactions that can delete a range of keys
+// at once.
+type RangeDeleter interface {
+	// DeleteRange deletes all keys in [start, end).
+	DeleteRange(start, end []byte) error
+}
+
+// Deleter is implemented by transactions that can delete a key.
+type Deleter interface {
+	Delete(key []byte) error
+}
+
+// DeleteRange deletes all keys in [start, end) from tx, at once if tx is a
+// RangeDeleter, and otherwise one at a time if it is a Deleter.
+func DeleteRange(tx Transaction, start, end []byte) error {
+	if d, ok := tx.(RangeDeleter); ok {
+		return d.DeleteRange(start, end)
+	}
+	d, ok := tx.(Deleter)
+	if !ok {
+		return errors.New("transaction does not support deletes")
+	}
+	for {
+		k, _, err := tx.GetLT(end)
+		if err != nil {
+			return err
+		}
+		if k == nil || bytes.Compare(k, start) < 0 {
+			return nil
+		}
+		if err := d.Delete(k); err != nil {
+			return err
+		}
+		end = k
+	}
+}
@@ -5,11 +5,23 @@ import (
 	"io"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/mdehoog/indexed-merkle-tree/metrics"
 )
 
 type Pebble struct {
 	db           *pebble.DB
 	writeOptions *pebble.WriteOptions
+	recorder     metrics.Recorder
+}
+
+type PebbleOption func(*Pebble)
+
+// WithRecorder counts the reads and writes made through the database and its
+// transactions.
+func WithRecorder(recorder metrics.Recorder) PebbleOption {
+	return func(p *Pebble) {
+		p.recorder = recorder
+	}
 }
 
 type pebbleGetter interface {
@@ -19,25 +31,33 @@ type pebbleGetter interface {
 
 var _ Database = (*Pebble)(nil)
 
-func NewPebble(db *pebble.DB) *Pebble {
-	return &Pebble{
+func NewPebble(db *pebble.DB, opts ...PebbleOption) *Pebble {
+	p := &Pebble{
 		db:           db,
 		writeOptions: &pebble.WriteOptions{Sync: true},
+		recorder:     metrics.Nop,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 func (p *Pebble) NewTransaction() Transaction {
 	return &pebbleTransaction{
 		batch:        p.db.NewIndexedBatch(),
 		writeOptions: p.writeOptions,
+		recorder:     p.recorder,
 	}
 }
 
 func (p *Pebble) Get(key []byte) ([]byte, error) {
+	p.recorder.Add(metrics.DBGets, 1)
 	return get(key, p.db)
 }
 
 func (p *Pebble) GetLT(key []byte) ([]byte, []byte, error) {
+	p.recorder.Add(metrics.DBSeeks, 1)
 	return getLT(key, p.db)
 }
 
@@ -48,29 +68,76 @@ func (p *Pebble) Close() error {
 type pebbleTransaction struct {
 	batch        *pebble.Batch
 	writeOptions *pebble.WriteOptions
+	recorder     metrics.Recorder
+	before       []func() error
+	after        []func()
 }
 
 var _ Transaction = (*pebbleTransaction)(nil)
+var _ CommitHooks = (*pebbleTransaction)(nil)
+var _ RangeDeleter = (*pebbleTransaction)(nil)
+var _ Deleter = (*pebbleTransaction)(nil)
 
 func (p *pebbleTransaction) Get(key []byte) ([]byte, error) {
+	p.recorder.Add(metrics.DBGets, 1)
 	return get(key, p.batch)
 }
 
 func (p *pebbleTransaction) GetLT(key []byte) ([]byte, []byte, error) {
+	p.recorder.Add(metrics.DBSeeks, 1)
 	return getLT(key, p.batch)
 }
 
 func (p *pebbleTransaction) Set(key []byte, value []byte) error {
+	p.recorder.Add(metrics.DBSets, 1)
 	return p.batch.Set(key, value, p.writeOptions)
 }
 
+func (p *pebbleTransaction) Delete(key []byte) error {
+	p.recorder.Add(metrics.DBDeletes, 1)
+	return p.batch.Delete(key, p.writeOptions)
+}
+
+func (p *pebbleTransaction) DeleteRange(start, end []byte) error {
+	p.recorder.Add(metrics.DBDeletes, 1)
+	return p.batch.DeleteRange(start, end, p.writeOptions)
+}
+
+func (p *pebbleTransaction) BeforeCommit(fn func() error) {
+	p.before = append(p.before, fn)
+}
+
+func (p *pebbleTransaction) AfterCommit(fn func()) {
+	p.after = append(p.after, fn)
+}
+
 func (p *pebbleTransaction) Commit() error {
 	if p.batch == nil {
 		return errors.New("commit: transaction already committed")
 	}
+	if err := p.runBefore(); err != nil {
+		return err
+	}
 	err := p.batch.Commit(p.writeOptions)
 	p.batch = nil
-	return err
+	if err != nil {
+		return err
+	}
+	for _, fn := range p.after {
+		fn()
+	}
+	p.after = nil
+	return nil
+}
+
+func (p *pebbleTransaction) runBefore() error {
+	for _, fn := range p.before {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	p.before = nil
+	return nil
 }
 
 func (p *pebbleTransaction) Discard() {
@@ -86,7 +153,16 @@ func (p *pebbleTransaction) Apply(transaction Transaction) error {
 	if !ok {
 		return errors.New("apply: incompatible transaction types")
 	}
-	return p.batch.Apply(otherPebble.batch, nil)
+	// the other transaction is committed as part of this one
+	if err := otherPebble.runBefore(); err != nil {
+		return err
+	}
+	if err := p.batch.Apply(otherPebble.batch, nil); err != nil {
+		return err
+	}
+	p.after = append(p.after, otherPebble.after...)
+	otherPebble.after = nil
+	return nil
 }
 
 func get(key []byte, g pebbleGetter) ([]byte, error) {
@@ -117,5 +193,10 @@ func getLT(key []byte, g pebbleGetter) ([]byte, []byte, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	return iter.Key(), v, nil
+	// the iterator's buffers are only valid until it is closed
+	k := make([]byte, len(iter.Key()))
+	copy(k, iter.Key())
+	ret := make([]byte, len(v))
+	copy(ret, v)
+	return k, ret, nil
 }
@@ -0,0 +1,77 @@
+package imt
+
+import "errors"
+
+// builder computes every hash of a tree bottom-up from its leaf hashes,
+// which must be pushed in index order starting at 0. Only one pending hash
+// per level is held in memory.
+type builder struct {
+	levels  uint64
+	hash    func(elements ...element) (element, error)
+	set     func(index, level uint64, h element) error
+	pending []*pendingHash
+}
+
+type pendingHash struct {
+	index uint64
+	hash  element
+}
+
+func newBuilder(levels uint64, hash func(elements ...element) (element, error), set func(index, level uint64, h element) error) *builder {
+	return &builder{
+		levels:  levels,
+		hash:    hash,
+		set:     set,
+		pending: make([]*pendingHash, levels+1),
+	}
+}
+
+func (b *builder) pushLeaf(index uint64, h element) error {
+	return b.push(index, b.levels, h)
+}
+
+func (b *builder) push(index, level uint64, h element) error {
+	for {
+		err := b.set(index, level, h)
+		if err != nil {
+			return err
+		}
+		if level == 0 {
+			if index != 0 {
+				return errors.New("tree is over capacity")
+			}
+			return nil
+		}
+		if index%2 == 0 {
+			b.pending[level] = &pendingHash{index: index, hash: h}
+			return nil
+		}
+		left := b.pending[level]
+		if left == nil || left.index != index-1 {
+			return errors.New("leaves pushed out of order")
+		}
+		b.pending[level] = nil
+		h, err = b.hash(left.hash, h)
+		if err != nil {
+			return err
+		}
+		index /= 2
+		level--
+	}
+}
+
+// finish promotes every left child without a right sibling to its parent.
+func (b *builder) finish() error {
+	for level := b.levels; level > 0; level-- {
+		p := b.pending[level]
+		if p == nil {
+			continue
+		}
+		b.pending[level] = nil
+		err := b.push(p.index/2, level-1, p.hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package imt
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// KeyValueIterator is a stream of key/value pairs.
+type KeyValueIterator interface {
+	Next() bool
+	Key() *big.Int
+	Value() *big.Int
+	Err() error
+}
+
+// bulkLoadBatchSize is the number of nodes BulkLoad writes per transaction.
+var bulkLoadBatchSize uint64 = 1 << 16
+
+// BulkLoad populates an empty tree from key/value pairs sorted by strictly
+// increasing key. Indices are assigned in key order, and every level is built
+// bottom-up in a single pass, producing the same tree as inserting the keys in
+// order.
+//
+// The nodes and hashes are committed in transactions of bounded size, keeping
+// only one pending hash per level in memory between them, and the size is
+// committed last. A load that fails leaves a partially loaded tree, which must
+// be discarded.
+func BulkLoad(database db.Database, levels, feLen uint64, hash HashFn, it KeyValueIterator, opts ...Option) error {
+	o := newOptions(opts)
+	// the batches are not states of the tree, so they are not observed or
+	// recorded in the history
+	batch := o
+	batch.observers = nil
+	batch.historyLevels = 0
+	t := newTreeWriter(database.NewTransaction(), levels, feLen, hash, batch)
+	defer func() {
+		t.tx.Discard()
+	}()
+	size, err := t.Size()
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		return errors.New("bulk load requires an empty tree")
+	}
+
+	b := newBuilder(levels, t.hashElements, func(index, level uint64, h element) error {
+		return t.setHash(index, level, h)
+	})
+	prev := initialStateNode()
+	for it.Next() {
+		key, err := t.newElement(it.Key())
+		if err != nil {
+			return err
+		}
+		value, err := t.newElement(it.Value())
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(key[:], prev.key[:]) <= 0 {
+			return errors.New("keys must be non-zero and strictly increasing")
+		}
+		prev.nextKey = key
+		err = t.load(b, prev)
+		if err != nil {
+			return err
+		}
+		if (prev.index+1)%bulkLoadBatchSize == 0 {
+			if err := t.Commit(); err != nil {
+				return err
+			}
+			t = newTreeWriter(database.NewTransaction(), levels, feLen, hash, batch)
+		}
+		prev = &node{
+			key:   key,
+			index: prev.index + 1,
+			value: value,
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if prev.index == 0 {
+		return nil
+	}
+
+	err = t.load(b, prev)
+	if err != nil {
+		return err
+	}
+	err = b.finish()
+	if err != nil {
+		return err
+	}
+	if err := t.Commit(); err != nil {
+		return err
+	}
+	t = newTreeWriter(database.NewTransaction(), levels, feLen, hash, o)
+	err = t.setSize(prev.index)
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// load writes a node and pushes its hash into the builder.
+func (t *treeWriter) load(b *builder, n *node) error {
+	err := t.setNodes(n)
+	if err != nil {
+		return err
+	}
+	h, err := t.hashNode(n)
+	if err != nil {
+		return err
+	}
+	return b.pushLeaf(n.index, h)
+}
@@ -0,0 +1,103 @@
+package imt
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+type sliceIterator struct {
+	ops []testOp
+	i   int
+}
+
+func (s *sliceIterator) Next() bool      { s.i++; return s.i <= len(s.ops) }
+func (s *sliceIterator) Key() *big.Int   { return s.ops[s.i-1].key }
+func (s *sliceIterator) Value() *big.Int { return s.ops[s.i-1].value }
+func (s *sliceIterator) Err() error      { return nil }
+
+func sortedOps(n int, seed int64) []testOp {
+	keys := testKeys(n, seed)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Cmp(keys[j]) < 0 })
+	ops := make([]testOp, len(keys))
+	for i, k := range keys {
+		ops[i] = testOp{k, big.NewInt(int64(i * 7))}
+	}
+	return ops
+}
+
+func TestBulkLoad(t *testing.T) {
+	defer func(n uint64) { bulkLoadBatchSize = n }(bulkLoadBatchSize)
+	bulkLoadBatchSize = 16
+
+	for _, n := range []int{0, 1, 2, 3, 4, 5, 15, 16, 17, 100, 255} {
+		ops := sortedOps(n, int64(n))
+		want, _, wantRoot := testBuild(t, ops, 8)
+
+		p, d := testPebble(t)
+		if err := BulkLoad(d, 8, fr.Bytes, testHash, &sliceIterator{ops: ops}); err != nil {
+			t.Fatal(err)
+		}
+		root, err := NewTreeReader(d, 8, fr.Bytes, testHash).Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testDump(t, p) != want || root.Cmp(wantRoot) != 0 {
+			t.Fatalf("%d keys: bulk load differs from inserts", n)
+		}
+	}
+}
+
+func TestBulkLoadCache(t *testing.T) {
+	defer func(n uint64) { bulkLoadBatchSize = n }(bulkLoadBatchSize)
+	bulkLoadBatchSize = 16
+
+	ops := sortedOps(100, 1)
+	d := testDB(t)
+	c := NewCache(1000, 4)
+	if err := BulkLoad(d, 8, fr.Bytes, testHash, &sliceIterator{ops: ops}, WithCache(c)); err != nil {
+		t.Fatal(err)
+	}
+	cached := NewTreeReader(d, 8, fr.Bytes, testHash, WithCache(c))
+	plain := NewTreeReader(d, 8, fr.Bytes, testHash)
+	for _, op := range ops {
+		a, err := cached.ProveInclusion(op.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := plain.ProveInclusion(op.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			t.Fatalf("cached proof %v, want %v", a, b)
+		}
+	}
+	if c.Stats().Hits == 0 {
+		t.Fatal("bulk load did not populate the cache")
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	d := testDB(t)
+	if err := BulkLoad(d, 8, fr.Bytes, testHash, &sliceIterator{ops: sortedOps(256, 1)}); err == nil {
+		t.Fatal("expected capacity error")
+	}
+
+	ops := sortedOps(3, 1)
+	ops[1], ops[2] = ops[2], ops[1]
+	if err := BulkLoad(testDB(t), 8, fr.Bytes, testHash, &sliceIterator{ops: ops}); err == nil {
+		t.Fatal("expected unsorted keys to fail")
+	}
+
+	d = testDB(t)
+	if err := BulkLoad(d, 8, fr.Bytes, testHash, &sliceIterator{ops: sortedOps(3, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := BulkLoad(d, 8, fr.Bytes, testHash, &sliceIterator{ops: sortedOps(3, 2)}); err == nil {
+		t.Fatal("expected load into a non-empty tree to fail")
+	}
+}
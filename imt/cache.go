@@ -0,0 +1,132 @@
+package imt
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a bounded in-memory cache of the hashes of a single tree. The top
+// levels of the tree can be pinned, in which case they are never evicted.
+//
+// A Cache only holds committed hashes: readers populate it from their
+// db.Reader, which must therefore read committed state, and writers read it
+// without populating it and publish the hashes they write once their
+// transaction is committed. Readers running concurrently with a writer should
+// use a Tree to stay consistent.
+type Cache struct {
+	mu           sync.Mutex
+	size         int
+	pinnedLevels uint64
+	pinned       map[hashPosition]cachedHash
+	entries      map[hashPosition]*list.Element
+	lru          *list.List
+	stats        CacheStats
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// hashPosition is the index and level of a hash in the tree.
+type hashPosition struct {
+	index uint64
+	level uint64
+}
+
+// lastLeaf returns the greatest leaf index in the subtree at the position.
+func (p hashPosition) lastLeaf(levels uint64) uint64 {
+	d := levels - p.level
+	return p.index<<d + (1<<d - 1)
+}
+
+// cachedHash is a hash, or the knowledge that there is none if !ok.
+type cachedHash struct {
+	hash element
+	ok   bool
+}
+
+type cacheEntry struct {
+	cachedHash
+	position hashPosition
+}
+
+// NewCache returns a cache holding up to size hashes in an LRU, in addition to
+// every hash in the top pinnedLevels levels of the tree.
+func NewCache(size int, pinnedLevels uint64) *Cache {
+	return &Cache{
+		size:         size,
+		pinnedLevels: pinnedLevels,
+		pinned:       make(map[hashPosition]cachedHash),
+		entries:      make(map[hashPosition]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// get returns whether the hash was found in the cache, and if so the hash and
+// whether it exists.
+func (c *Cache) get(index, level uint64) (hash element, ok bool, found bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := hashPosition{index: index, level: level}
+	if level < c.pinnedLevels {
+		h, found := c.pinned[p]
+		c.count(found)
+		return h.hash, h.ok, found
+	}
+	e, found := c.entries[p]
+	c.count(found)
+	if !found {
+		return
+	}
+	c.lru.MoveToFront(e)
+	h := e.Value.(*cacheEntry)
+	return h.hash, h.ok, true
+}
+
+func (c *Cache) count(hit bool) {
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+func (c *Cache) add(index, level uint64, hash element, ok bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := hashPosition{index: index, level: level}
+	h := cachedHash{hash: hash, ok: ok}
+	if level < c.pinnedLevels {
+		c.pinned[p] = h
+		return
+	}
+	if e, found := c.entries[p]; found {
+		e.Value.(*cacheEntry).cachedHash = h
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+	c.entries[p] = c.lru.PushFront(&cacheEntry{cachedHash: h, position: p})
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).position)
+		c.stats.Evictions++
+	}
+}
@@ -0,0 +1,125 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestCacheConsistency(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		d := testDB(t)
+		c := NewCache(20, 3)
+		opts := []Option{WithCache(c)}
+		if deferred {
+			opts = append(opts, WithDeferredHashing(false))
+		}
+		tree, err := NewTree(d, 10, fr.Bytes, testHash, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := NewTreeReader(d, 10, fr.Bytes, testHash)
+
+		ops := testOps(80)
+		for i := 0; i < len(ops); i += 7 {
+			end := min(i+7, len(ops))
+
+			// hashes written by a discarded update must not reach the cache
+			_ = tree.Update(func(w TreeWriter) error {
+				if _, err := w.Set(big.NewInt(int64(1000000+i)), big.NewInt(5)); err != nil {
+					return err
+				}
+				if _, err := w.Root(); err != nil {
+					return err
+				}
+				return errors.New("discard")
+			})
+			err := tree.Update(func(w TreeWriter) error {
+				for _, op := range ops[i:end] {
+					if _, err := w.Set(op.key, op.value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, op := range ops[:end] {
+				cached, err := tree.ProveInclusion(op.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				uncached, err := plain.ProveInclusion(op.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(cached) != fmt.Sprint(uncached) {
+					t.Fatalf("deferred %v: cached proof %v, want %v", deferred, cached, uncached)
+				}
+			}
+			root, err := plain.Root()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root.Cmp(tree.Root()) != 0 {
+				t.Fatalf("deferred %v: cached root differs", deferred)
+			}
+		}
+
+		s := c.Stats()
+		if s.Hits == 0 || s.Evictions == 0 {
+			t.Fatalf("deferred %v: unexpected stats %+v", deferred, s)
+		}
+	}
+}
+
+func TestCacheDirectCommit(t *testing.T) {
+	d := testDB(t)
+	c := NewCache(100, 3)
+	ops := testOps(40)
+	for i, op := range ops {
+		r := NewTreeReader(d, 10, fr.Bytes, testHash, WithCache(c))
+		if _, err := r.Root(); err != nil {
+			t.Fatal(err)
+		}
+
+		// reads by a discarded writer must not reach the cache
+		tx := d.NewTransaction()
+		w := NewTreeWriter(tx, 10, fr.Bytes, testHash, WithCache(c))
+		if _, err := w.Set(big.NewInt(int64(1000000+i)), big.NewInt(5)); err != nil {
+			t.Fatal(err)
+		}
+		tx.Discard()
+
+		// committing the transaction directly publishes the written hashes
+		tx = d.NewTransaction()
+		w = NewTreeWriter(tx, 10, fr.Bytes, testHash, WithCache(c))
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+		// a concurrent reader caches the hashes being replaced
+		if _, err := r.ProveInclusion(ops[0].key); err != nil && i > 0 {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		cached, err := r.ProveInclusion(op.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uncached, err := NewTreeReader(d, 10, fr.Bytes, testHash).ProveInclusion(op.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(cached) != fmt.Sprint(uncached) {
+			t.Fatalf("cached proof %v, want %v", cached, uncached)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+type ViolationKind int
+
+const (
+	// ViolationCorruptNode is a node record that cannot be decoded.
+	ViolationCorruptNode ViolationKind = iota
+	// ViolationBrokenLink is a node whose nextKey is not the next stored key.
+	ViolationBrokenLink
+	// ViolationMissingInitialNode is a non-empty tree without the key 0 node
+	// at index 0.
+	ViolationMissingInitialNode
+	// ViolationIndexOutOfRange is a node with an index greater than the size.
+	ViolationIndexOutOfRange
+	// ViolationDuplicateIndex is a node sharing its index with another node.
+	ViolationDuplicateIndex
+	// ViolationMissingIndex is a run of indices in [0, size] without a node,
+	// starting at Index.
+	ViolationMissingIndex
+	// ViolationMissingHash is a hash that should exist but is not stored.
+	ViolationMissingHash
+	// ViolationHashMismatch is a stored hash that differs from its
+	// recomputation.
+	ViolationHashMismatch
+	// ViolationSizeOutOfRange is a stored size beyond the capacity of the
+	// tree. Indices and hashes are not checked against it.
+	ViolationSizeOutOfRange
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case ViolationCorruptNode:
+		return "corrupt node"
+	case ViolationBrokenLink:
+		return "broken link"
+	case ViolationMissingInitialNode:
+		return "missing initial node"
+	case ViolationIndexOutOfRange:
+		return "index out of range"
+	case ViolationDuplicateIndex:
+		return "duplicate index"
+	case ViolationMissingIndex:
+		return "missing index"
+	case ViolationMissingHash:
+		return "missing hash"
+	case ViolationHashMismatch:
+		return "hash mismatch"
+	case ViolationSizeOutOfRange:
+		return "size out of range"
+	}
+	return fmt.Sprintf("ViolationKind(%d)", int(k))
+}
+
+// Violation describes a single inconsistency. Key is nil for violations that
+// are not about a node, and Level is only set for hash violations.
+type Violation struct {
+	Kind    ViolationKind
+	Key     *big.Int
+	Index   uint64
+	Level   uint64
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Kind, v.Message)
+}
+
+type CheckReport struct {
+	Root       *big.Int
+	Size       uint64
+	Nodes      uint64
+	Violations []Violation
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *CheckReport) add(v Violation) {
+	r.Violations = append(r.Violations, v)
+}
+
+// baseReader is implemented by the trees in this package, giving access to the
+// underlying treeReader once any pending hashes are written.
+type baseReader interface {
+	base() (*treeReader, error)
+}
+
+func (t *treeReader) base() (*treeReader, error) {
+	return t, nil
+}
+
+func (t *treeWriter) base() (*treeReader, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader, nil
+}
+
+func unwrap(t TreeReader) (*treeReader, error) {
+	b, ok := t.(baseReader)
+	if !ok {
+		return nil, errors.New("unsupported tree reader")
+	}
+	return b.base()
+}
+
+// Check verifies the integrity of a tree: that the stored nodes form a single
+// sorted linked list starting at the key 0 node, that every index in
+// [0, size] belongs to exactly one node, and that every hash matches its
+// recomputation. Violations are collected in the returned report; the error is
+// only set if the tree could not be read.
+func Check(tree TreeReader) (*CheckReport, error) {
+	t, err := unwrap(tree)
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	root, err := t.Root()
+	if err != nil {
+		return nil, err
+	}
+	r := &CheckReport{
+		Root: root,
+		Size: size,
+	}
+	validSize := t.inCapacity(size)
+	if !validSize {
+		r.add(Violation{
+			Kind:    ViolationSizeOutOfRange,
+			Message: fmt.Sprintf("size %d exceeds the capacity of %d levels", size, t.levels),
+		})
+	}
+	maxIndex, err := t.checkNodes(r, validSize)
+	if err != nil {
+		return nil, err
+	}
+	if validSize {
+		if err := t.checkHashes(r, maxIndex); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// checkNodes visits every stored node in descending key order, checking that
+// each links to the node visited before it, and returns the greatest index in
+// range. Missing indices are only reported if the size is valid, and are found
+// from the sorted indices of the stored nodes, so the work is proportional to
+// the number of nodes rather than the size.
+func (t *treeReader) checkNodes(r *CheckReport, validSize bool) (uint64, error) {
+	seen := make(map[uint64]bool)
+	var next element
+	var last *node
+	err := t.forEachNodeDescending(func(k, v []byte) error {
+		r.Nodes++
+		key, err := nodeKeyBytesToKey(k)
+		if err == nil && len(k) != 1+int(t.feLen) {
+			err = errors.New("invalid key length")
+		}
+		var n *node
+		if err == nil {
+			n, err = fromBytes(key, v)
+		}
+		if err != nil {
+			r.add(Violation{
+				Kind:    ViolationCorruptNode,
+				Key:     new(big.Int).SetBytes(k[1:]),
+				Message: fmt.Sprintf("node %x: %s", k[1:], err),
+			})
+			return nil
+		}
+		last = n
+
+		if n.nextKey != next {
+			r.add(Violation{
+				Kind:    ViolationBrokenLink,
+				Key:     n.Key(),
+				Index:   n.index,
+				Message: fmt.Sprintf("node %s has next key %s, expected %s", n.key, n.nextKey, next),
+			})
+		}
+		next = n.key
+
+		if n.index > r.Size || !t.inCapacity(n.index) {
+			r.add(Violation{
+				Kind:    ViolationIndexOutOfRange,
+				Key:     n.Key(),
+				Index:   n.index,
+				Message: fmt.Sprintf("node %s has index %d, size is %d", n.key, n.index, r.Size),
+			})
+			return nil
+		}
+		if seen[n.index] {
+			r.add(Violation{
+				Kind:    ViolationDuplicateIndex,
+				Key:     n.Key(),
+				Index:   n.index,
+				Message: fmt.Sprintf("node %s has duplicate index %d", n.key, n.index),
+			})
+			return nil
+		}
+		seen[n.index] = true
+		return t.checkLeaf(r, n)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if r.Size == 0 && r.Nodes == 0 {
+		return 0, nil
+	}
+	if last == nil || !last.key.isZero() || last.index != 0 {
+		r.add(Violation{
+			Kind:    ViolationMissingInitialNode,
+			Message: "the first node is not key 0 at index 0",
+		})
+	}
+	indices := make([]uint64, 0, len(seen))
+	for index := range seen {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	var maxIndex uint64
+	if len(indices) > 0 {
+		maxIndex = indices[len(indices)-1]
+	}
+	if !validSize {
+		return maxIndex, nil
+	}
+	// report each run of indices in [0, size] without a node
+	from := uint64(0)
+	for _, index := range append(indices, r.Size+1) {
+		if index > from {
+			r.addMissing(from, index-1)
+		}
+		from = index + 1
+	}
+	return maxIndex, nil
+}
+
+func (r *CheckReport) addMissing(from, to uint64) {
+	message := fmt.Sprintf("no node has index %d", from)
+	if to > from {
+		message = fmt.Sprintf("no node has an index in [%d, %d]", from, to)
+	}
+	r.add(Violation{
+		Kind:    ViolationMissingIndex,
+		Index:   from,
+		Message: message,
+	})
+}
+
+func (t *treeReader) checkLeaf(r *CheckReport, n *node) error {
+	h, err := n.hash(t.hash)
+	if err != nil {
+		return err
+	}
+	return t.checkHash(r, n.index, t.levels, h)
+}
+
+// checkHashes checks that every stored internal hash in the tree, up to the
+// ancestors of maxIndex, matches the hash of its stored children.
+func (t *treeReader) checkHashes(r *CheckReport, maxIndex uint64) error {
+	if r.Size == 0 {
+		return nil
+	}
+	for level := t.levels; level > 0; level-- {
+		// shifting by 64 or more bits gives 0 in trees of 64 or more levels
+		last := maxIndex >> (t.levels - level)
+		// iterate over pairs, as index+2 could overflow
+		for pair := uint64(0); pair <= last/2; pair++ {
+			index := pair * 2
+			left, err := t.readHash(index, level)
+			if errors.Is(err, db.ErrNotFound) {
+				// reported when checking this level's hash
+				continue
+			} else if err != nil {
+				return err
+			}
+			h := left
+			if index+1 <= last {
+				right, err := t.readHash(index+1, level)
+				if errors.Is(err, db.ErrNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				h, err = hashElements(t.hash, left, right)
+				if err != nil {
+					return err
+				}
+			}
+			err = t.checkHash(r, index/2, level-1, h)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *treeReader) checkHash(r *CheckReport, index, level uint64, expected element) error {
+	h, err := t.readHash(index, level)
+	if errors.Is(err, db.ErrNotFound) {
+		r.add(Violation{
+			Kind:    ViolationMissingHash,
+			Index:   index,
+			Level:   level,
+			Message: fmt.Sprintf("no hash at index %d, level %d", index, level),
+		})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if h != expected {
+		r.add(Violation{
+			Kind:    ViolationHashMismatch,
+			Index:   index,
+			Level:   level,
+			Message: fmt.Sprintf("hash at index %d, level %d is %s, expected %s", index, level, h, expected),
+		})
+	}
+	return nil
+}
+
+// forEachNodeDescending calls fn with the key and value of every stored node,
+// in descending key order.
+func (t *treeReader) forEachNodeDescending(fn func(k, v []byte) error) error {
+	k := []byte{nodeKeyPrefix + 1}
+	for {
+		var v []byte
+		var err error
+		k, v, err = t.reader.GetLT(k)
+		if err != nil {
+			return err
+		}
+		if len(k) == 0 || k[0] != nodeKeyPrefix {
+			return nil
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+}
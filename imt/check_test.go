@@ -0,0 +1,136 @@
+package imt
+
+import (
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestCheck(t *testing.T) {
+	ops := testOps(40)
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash)
+	for _, op := range ops {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := Check(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() {
+		t.Fatal(r.Violations)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewTreeReader(d, 10, fr.Bytes, testHash).(*treeReader)
+	if r, err = Check(reader); err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.Nodes != 41 {
+		t.Fatalf("%d nodes, violations %v", r.Nodes, r.Violations)
+	}
+
+	// corrupt an internal hash, a node's value, and delete a node
+	if err := p.Set(reader.hashKey(1, 5), []byte{1, 2, 3}, pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+	k, _ := newElement(ops[5].key)
+	n, err := reader.node(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.value = elementFromUint64(99)
+	if err := p.Set(reader.nodeKey(k), n.bytes(), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+	k, _ = newElement(ops[9].key)
+	if err := p.Delete(reader.nodeKey(k), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err = Check(reader); err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[ViolationKind]bool)
+	for _, v := range r.Violations {
+		kinds[v.Kind] = true
+	}
+	for _, kind := range []ViolationKind{ViolationBrokenLink, ViolationMissingIndex, ViolationHashMismatch} {
+		if !kinds[kind] {
+			t.Errorf("no %s violation in %v", kind, r.Violations)
+		}
+	}
+}
+
+func TestCheckSizeOutOfRange(t *testing.T) {
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash)
+	for _, op := range testOps(5) {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	size := elementFromUint64(^uint64(0))
+	if err := p.Set(sizeKey, size.bytes(), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewTreeReader(d, 10, fr.Bytes, testHash)
+	r, err := Check(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Violations) == 0 || r.Violations[0].Kind != ViolationSizeOutOfRange {
+		t.Fatalf("got violations %v", r.Violations)
+	}
+	if err := reader.Export(io.Discard); err == nil {
+		t.Fatal("expected export of an out of range size to fail")
+	}
+}
+
+func TestCheckLevels64(t *testing.T) {
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 64, fr.Bytes, testHash)
+	for _, op := range testOps(5) {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	reader := NewTreeReader(d, 64, fr.Bytes, testHash)
+
+	// the work is proportional to the stored nodes, not the size
+	size := elementFromUint64(1 << 40)
+	if err := p.Set(sizeKey, size.bytes(), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Check(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Violations) != 1 || r.Violations[0].Kind != ViolationMissingIndex || r.Violations[0].Index != 6 {
+		t.Fatalf("got violations %v", r.Violations)
+	}
+
+	size = elementFromUint64(^uint64(0))
+	if err := p.Set(sizeKey, size.bytes(), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = Check(reader); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Violations) == 0 || r.Violations[0].Kind != ViolationSizeOutOfRange {
+		t.Fatalf("got violations %v", r.Violations)
+	}
+}
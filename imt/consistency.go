@@ -0,0 +1,276 @@
+package imt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// ConsistencyProof proves that a tree extends an older one: it is at least as
+// large, and every node of the old tree is still at the same index with the
+// same key. Only the old nodes that changed are listed, in key order in
+// OldNodes and NewNodes, along with the node at index OldSize; every other old
+// node is in a subtree whose hash is shared by both trees. Siblings is a
+// multiproof of the listed nodes in both trees, except for the siblings beyond
+// OldSize, which are empty in the old tree and in NewSiblings for the new one.
+type ConsistencyProof interface {
+	OldRoot() *big.Int
+	OldSize() uint64
+	NewRoot() *big.Int
+	NewSize() uint64
+	OldNodes() []Node
+	NewNodes() []Node
+	Siblings() []*big.Int
+	NewSiblings() []*big.Int
+	Updates() []Change
+	Valid(t TreeReader) (bool, error)
+}
+
+type consistencyProof struct {
+	oldRoot     element
+	oldSize     uint64
+	newRoot     element
+	newSize     uint64
+	oldNodes    []*node
+	newNodes    []*node
+	siblings    []element
+	newSiblings []element
+}
+
+var _ ConsistencyProof = (*consistencyProof)(nil)
+
+func (p *consistencyProof) OldRoot() *big.Int {
+	return p.oldRoot.BigInt()
+}
+
+func (p *consistencyProof) OldSize() uint64 {
+	return p.oldSize
+}
+
+func (p *consistencyProof) NewRoot() *big.Int {
+	return p.newRoot.BigInt()
+}
+
+func (p *consistencyProof) NewSize() uint64 {
+	return p.newSize
+}
+
+func (p *consistencyProof) OldNodes() []Node {
+	return nodes(p.oldNodes)
+}
+
+func (p *consistencyProof) NewNodes() []Node {
+	return nodes(p.newNodes)
+}
+
+func (p *consistencyProof) Siblings() []*big.Int {
+	return bigInts(p.siblings)
+}
+
+func (p *consistencyProof) NewSiblings() []*big.Int {
+	return bigInts(p.newSiblings)
+}
+
+// Updates returns the keys whose values changed, in key order.
+func (p *consistencyProof) Updates() []Change {
+	var updates []Change
+	for i, o := range p.oldNodes {
+		if n := p.newNodes[i]; n.value != o.value {
+			updates = append(updates, Change{Kind: ChangeUpdated, Key: o.Key(), OldValue: o.Value(), NewValue: n.Value()})
+		}
+	}
+	return updates
+}
+
+func (p *consistencyProof) String() string {
+	return fmt.Sprintf("ConsistencyProof{OldRoot: %s, OldSize: %d, NewRoot: %s, NewSize: %d, OldNodes: %v, NewNodes: %v, Siblings: %v, NewSiblings: %v}", p.oldRoot, p.oldSize, p.newRoot, p.newSize, p.oldNodes, p.newNodes, p.siblings, p.newSiblings)
+}
+
+// ProveConsistency proves that newTree extends oldTree, e.g. a later snapshot
+// of the same tree. It walks every node of the old tree, and fails if a key was
+// removed or moved.
+func ProveConsistency(oldTree, newTree TreeReader) (ConsistencyProof, error) {
+	o, err := newNodeWalker(oldTree)
+	if err != nil {
+		return nil, err
+	}
+	t, err := unwrap(newTree)
+	if err != nil {
+		return nil, err
+	}
+	if o.t.levels != t.levels {
+		return nil, errors.New("trees have different levels")
+	}
+	newSize, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	if newSize < o.size {
+		return nil, fmt.Errorf("tree shrank from %d to %d", o.size, newSize)
+	}
+	newRoot, err := t.root(t.hashes, newSize)
+	if err != nil {
+		return nil, err
+	}
+	p := &consistencyProof{
+		oldRoot: o.root,
+		oldSize: o.size,
+		newRoot: newRoot,
+		newSize: newSize,
+	}
+
+	if o.n == nil {
+		o.n = initialStateNode()
+	}
+	var last bool
+	for o.n != nil {
+		n, err := t.node(o.n.key)
+		if errors.Is(err, db.ErrNotFound) && o.n.key.isZero() {
+			n = initialStateNode()
+		} else if errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("key %s was removed", o.n.key)
+		} else if err != nil {
+			return nil, err
+		}
+		if n.index != o.n.index {
+			return nil, fmt.Errorf("key %s moved from index %d to %d", o.n.key, o.n.index, n.index)
+		}
+		if n.value != o.n.value || n.nextKey != o.n.nextKey || n.index == o.size {
+			p.oldNodes = append(p.oldNodes, o.n)
+			p.newNodes = append(p.newNodes, n)
+			last = last || n.index == o.size
+		}
+		if err := o.next(); err != nil {
+			return nil, err
+		}
+	}
+	if !last {
+		return nil, fmt.Errorf("no node at index %d", o.size)
+	}
+
+	indices := make([]uint64, len(p.oldNodes))
+	for i, n := range p.oldNodes {
+		indices[i] = n.index
+	}
+	for _, pos := range multiproofPositions(t.levels, indices) {
+		if pos.lastLeaf(t.levels) <= p.oldSize {
+			h, err := o.t.hashes.getHash(pos.index, pos.level)
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				return nil, err
+			}
+			p.siblings = append(p.siblings, h)
+		} else {
+			h, err := t.hashes.getHash(pos.index, pos.level)
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				return nil, err
+			}
+			p.newSiblings = append(p.newSiblings, h)
+		}
+	}
+	return p, nil
+}
+
+func (p *consistencyProof) Valid(t TreeReader) (bool, error) {
+	if p.newSize < p.oldSize || len(p.oldNodes) == 0 || len(p.oldNodes) != len(p.newNodes) {
+		return false, nil
+	}
+
+	// the listed nodes must keep their index and key
+	levels := t.Levels()
+	oldLeaves := make(map[uint64]element, len(p.oldNodes))
+	newLeaves := make(map[uint64]element, len(p.newNodes))
+	indices := make([]uint64, 0, len(p.oldNodes))
+	for i, o := range p.oldNodes {
+		n := p.newNodes[i]
+		if o.index>>levels != 0 || o.index > p.oldSize || n.index != o.index || n.key != o.key || !extends(o, n) {
+			return false, nil
+		}
+		if _, ok := oldLeaves[o.index]; ok {
+			return false, nil
+		}
+		h, err := o.hash(t.Hash)
+		if err != nil {
+			return false, err
+		}
+		oldLeaves[o.index] = h
+		if h, err = n.hash(t.Hash); err != nil {
+			return false, err
+		}
+		newLeaves[n.index] = h
+		indices = append(indices, o.index)
+	}
+	if _, ok := oldLeaves[p.oldSize]; !ok {
+		return false, nil
+	}
+
+	// as the node at OldSize is listed, every sibling is either a subtree of
+	// old nodes, shared by both trees, or a subtree of new nodes only
+	positions := multiproofPositions(levels, indices)
+	oldSiblings := make([]element, len(positions))
+	newSiblings := make([]element, len(positions))
+	shared, added := p.siblings, p.newSiblings
+	for i, pos := range positions {
+		if pos.lastLeaf(levels) <= p.oldSize {
+			if len(shared) == 0 {
+				return false, nil
+			}
+			oldSiblings[i], newSiblings[i] = shared[0], shared[0]
+			shared = shared[1:]
+		} else {
+			if len(added) == 0 {
+				return false, nil
+			}
+			newSiblings[i] = added[0]
+			added = added[1:]
+		}
+	}
+	if len(shared) != 0 || len(added) != 0 {
+		return false, nil
+	}
+
+	for _, r := range []struct {
+		leaves   map[uint64]element
+		siblings []element
+		size     uint64
+		root     element
+	}{
+		{oldLeaves, oldSiblings, p.oldSize, p.oldRoot},
+		{newLeaves, newSiblings, p.newSize, p.newRoot},
+	} {
+		root, ok, err := multiproofRoot(t.Hash, levels, r.leaves, r.siblings)
+		if err != nil || !ok {
+			return false, err
+		}
+		h, err := hashElements(t.Hash, root, elementFromUint64(r.size))
+		if err != nil {
+			return false, err
+		}
+		if h != r.root {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// extends reports whether n can follow from o by inserts: its next key is
+// after its key, and no later than the old next key.
+func extends(o, n *node) bool {
+	if n.nextKey.isZero() {
+		return o.nextKey.isZero()
+	}
+	if bytes.Compare(n.nextKey[:], o.key[:]) <= 0 {
+		return false
+	}
+	return o.nextKey.isZero() || bytes.Compare(n.nextKey[:], o.nextKey[:]) <= 0
+}
+
+func nodes(n []*node) []Node {
+	nodes := make([]Node, len(n))
+	for i, m := range n {
+		nodes[i] = m
+	}
+	return nodes
+}
@@ -0,0 +1,137 @@
+package imt
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestConsistencyProof(t *testing.T) {
+	const levels = 8
+	ops := testOps(90)
+	build := func(ops []testOp) TreeWriter {
+		w := NewTreeWriter(testDB(t).NewTransaction(), levels, fr.Bytes, testHash)
+		for _, op := range ops {
+			if _, err := w.Set(op.key, op.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	for _, c := range [][2]int{{0, 0}, {0, 5}, {1, 2}, {10, 10}, {10, 11}, {30, 60}, {59, len(ops)}, {100, len(ops)}, {0, len(ops)}} {
+		o, n := build(ops[:c[0]]), build(ops[:c[1]])
+		p, err := ProveConsistency(o, n)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if ok, err := p.Valid(n); !ok || err != nil {
+			t.Fatalf("%v: valid = %v, %v", c, ok, err)
+		}
+		oldRoot, err := o.Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		newRoot, err := n.Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.OldRoot().Cmp(oldRoot) != 0 || p.NewRoot().Cmp(newRoot) != 0 {
+			t.Fatalf("%v: proof is not of the trees' roots", c)
+		}
+		var updates int
+		err = Diff(o, n, false, func(ch Change) error {
+			if ch.Kind == ChangeUpdated {
+				updates++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Updates()) != updates {
+			t.Fatalf("%v: got %d updates, want %d", c, len(p.Updates()), updates)
+		}
+
+		cp := p.(*consistencyProof)
+		for name, tamper := range map[string]func(q *consistencyProof) bool{
+			"new size": func(q *consistencyProof) bool {
+				q.newSize = q.oldSize - 1
+				return true
+			},
+			"old size": func(q *consistencyProof) bool {
+				q.oldSize++
+				return true
+			},
+			"no node at old size": func(q *consistencyProof) bool {
+				for i, m := range q.oldNodes {
+					if m.index == q.oldSize {
+						q.oldNodes = append(q.oldNodes[:i], q.oldNodes[i+1:]...)
+						q.newNodes = append(q.newNodes[:i], q.newNodes[i+1:]...)
+					}
+				}
+				return true
+			},
+			"key": func(q *consistencyProof) bool {
+				m := *q.newNodes[len(q.newNodes)-1]
+				m.key = elementFromUint64(12345)
+				q.newNodes[len(q.newNodes)-1] = &m
+				return true
+			},
+			"value": func(q *consistencyProof) bool {
+				m := *q.newNodes[0]
+				m.value = elementFromUint64(777777)
+				q.newNodes[0] = &m
+				return true
+			},
+			"sibling": func(q *consistencyProof) bool {
+				if len(q.siblings) == 0 {
+					return false
+				}
+				q.siblings[0] = elementFromUint64(1)
+				return true
+			},
+			"new sibling": func(q *consistencyProof) bool {
+				if len(q.newSiblings) == 0 {
+					return false
+				}
+				q.newSiblings[0] = elementFromUint64(1)
+				return true
+			},
+			"extra sibling": func(q *consistencyProof) bool {
+				if len(q.newSiblings) == 0 {
+					return false
+				}
+				q.siblings = append(q.siblings, q.newSiblings[0])
+				return true
+			},
+		} {
+			q := *cp
+			q.oldNodes = append([]*node{}, cp.oldNodes...)
+			q.newNodes = append([]*node{}, cp.newNodes...)
+			q.siblings = append([]element{}, cp.siblings...)
+			q.newSiblings = append([]element{}, cp.newSiblings...)
+			if !tamper(&q) {
+				continue
+			}
+			if ok, err := q.Valid(n); ok || err != nil {
+				t.Fatalf("%v: %s: valid = %v, %v", c, name, ok, err)
+			}
+		}
+	}
+
+	// the same keys inserted in another order
+	reversed := make([]testOp, len(ops))
+	for i, op := range ops {
+		reversed[len(ops)-1-i] = op
+	}
+	if _, err := ProveConsistency(build(ops[:50]), build(reversed)); err == nil {
+		t.Fatal("expected an error for moved keys")
+	}
+	if _, err := ProveConsistency(build(ops[:60]), build(ops[:50])); err == nil {
+		t.Fatal("expected an error for a smaller tree")
+	}
+	if _, err := ProveConsistency(build(ops[:10]), build(ops[20:40])); err == nil {
+		t.Fatal("expected an error for removed keys")
+	}
+}
@@ -0,0 +1,181 @@
+package imt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeUpdated
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeUpdated:
+		return "updated"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change is a difference between two trees. OldValue is nil for added keys,
+// and NewValue is nil for removed keys. If proofs are requested, OldProof and
+// NewProof prove the inclusion or exclusion of the key in each tree.
+type Change struct {
+	Kind     ChangeKind
+	Key      *big.Int
+	OldValue *big.Int
+	NewValue *big.Int
+	OldProof Proof
+	NewProof Proof
+}
+
+// Diff calls fn with every change from one tree to another, in key order, by
+// walking the sorted node lists of both trees in lockstep.
+func Diff(from, to TreeReader, proofs bool, fn func(Change) error) error {
+	a, err := newNodeWalker(from)
+	if err != nil {
+		return err
+	}
+	b, err := newNodeWalker(to)
+	if err != nil {
+		return err
+	}
+	if a.root == b.root {
+		return nil
+	}
+
+	// skip the initial nodes
+	if err := a.next(); err != nil {
+		return err
+	}
+	if err := b.next(); err != nil {
+		return err
+	}
+	for a.n != nil || b.n != nil {
+		var c Change
+		var cmp int
+		switch {
+		case a.n == nil:
+			cmp = 1
+		case b.n == nil:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(a.n.key[:], b.n.key[:])
+		}
+		switch {
+		case cmp < 0:
+			c = Change{Kind: ChangeRemoved, Key: a.n.Key(), OldValue: a.n.Value()}
+		case cmp > 0:
+			c = Change{Kind: ChangeAdded, Key: b.n.Key(), NewValue: b.n.Value()}
+		case a.n.value != b.n.value:
+			c = Change{Kind: ChangeUpdated, Key: a.n.Key(), OldValue: a.n.Value(), NewValue: b.n.Value()}
+		}
+
+		if c.Key != nil && proofs {
+			if c.OldProof, err = a.prove(cmp <= 0, b.n); err != nil {
+				return err
+			}
+			if c.NewProof, err = b.prove(cmp >= 0, a.n); err != nil {
+				return err
+			}
+		}
+		if c.Key != nil {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+
+		if cmp <= 0 {
+			if err := a.next(); err != nil {
+				return err
+			}
+		}
+		if cmp >= 0 {
+			if err := b.next(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nodeWalker walks the nodes of a tree in key order.
+type nodeWalker struct {
+	t     *treeReader
+	root  element
+	size  uint64
+	n     *node
+	steps uint64
+}
+
+func newNodeWalker(tree TreeReader) (*nodeWalker, error) {
+	t, err := unwrap(tree)
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	root, err := t.root(t.hashes, size)
+	if err != nil {
+		return nil, err
+	}
+	n, err := t.node(element{})
+	if errors.Is(err, db.ErrNotFound) {
+		n = nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &nodeWalker{
+		t:    t,
+		root: root,
+		size: size,
+		n:    n,
+	}, nil
+}
+
+func (w *nodeWalker) next() error {
+	if w.n == nil {
+		return nil
+	}
+	if w.n.nextKey.isZero() {
+		w.n = nil
+		return nil
+	}
+	w.steps++
+	if w.steps > w.size {
+		return errors.New("node list is longer than the tree size")
+	}
+	n, err := w.t.node(w.n.nextKey)
+	if err != nil {
+		return err
+	}
+	w.n = n
+	return nil
+}
+
+// prove proves the inclusion of the current node, or the exclusion of the
+// other walker's node.
+func (w *nodeWalker) prove(inclusion bool, other *node) (Proof, error) {
+	if inclusion {
+		return w.t.proveNode(w.n)
+	}
+	n, err := w.t.lowNullifierNode(other.key)
+	if err != nil {
+		return nil, err
+	}
+	return w.t.proveNode(n)
+}
@@ -0,0 +1,85 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// testTree commits the given values of keys to a new tree.
+func testTree(t testing.TB, keys []*big.Int, values map[int]int64) TreeReader {
+	d := testDB(t)
+	w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash)
+	for i, v := range values {
+		if _, err := w.Set(keys[i], big.NewInt(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return NewTreeReader(d, 10, fr.Bytes, testHash)
+}
+
+func TestDiff(t *testing.T) {
+	keys := testKeys(40, 5)
+	a := make(map[int]int64)
+	b := make(map[int]int64)
+	for i := 0; i < 30; i++ {
+		a[i] = int64(i)
+	}
+	for i := 5; i < 40; i++ {
+		b[i] = int64(i)
+	}
+	b[10] = 1000
+	b[20] = 2000
+	ta, tb := testTree(t, keys, a), testTree(t, keys, b)
+
+	counts := make(map[ChangeKind]int)
+	var prev *big.Int
+	err := Diff(ta, tb, true, func(c Change) error {
+		counts[c.Kind]++
+		if prev != nil && prev.Cmp(c.Key) >= 0 {
+			t.Fatalf("change of %s after %s", c.Key, prev)
+		}
+		prev = c.Key
+		for _, p := range []struct {
+			proof Proof
+			tree  TreeReader
+		}{{c.OldProof, ta}, {c.NewProof, tb}} {
+			ok, err := p.proof.Valid(p.tree)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				t.Fatalf("invalid proof for %s", c.Key)
+			}
+		}
+		if c.Kind == ChangeAdded && c.OldProof.Node().Key().Cmp(c.Key) >= 0 {
+			t.Fatalf("exclusion proof of %s is not of its low node", c.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[ChangeAdded] != 10 || counts[ChangeRemoved] != 5 || counts[ChangeUpdated] != 2 {
+		t.Fatalf("got changes %v", counts)
+	}
+
+	n := 0
+	err = Diff(ta, testTree(t, keys, nil), false, func(c Change) error {
+		if c.Kind != ChangeRemoved {
+			t.Fatalf("got %v, want only removals", c.Kind)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 30 {
+		t.Fatalf("got %d removals, want 30", n)
+	}
+}
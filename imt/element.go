@@ -0,0 +1,99 @@
+package imt
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const elementLen = 32
+
+// element is a fixed-size big-endian field element. Keys, values and hashes
+// are held as elements internally, and only converted to big.Int at the
+// public API boundary.
+type element [elementLen]byte
+
+var errElementOverflow = errors.New("value does not fit in a field element")
+
+func newElement(i *big.Int) (element, error) {
+	var e element
+	if i.Sign() < 0 || i.BitLen() > elementLen*8 {
+		return e, errElementOverflow
+	}
+	i.FillBytes(e[:])
+	return e, nil
+}
+
+// elementFromBytes parses big-endian bytes, such as those returned by
+// big.Int.Bytes.
+func elementFromBytes(b []byte) (element, error) {
+	var e element
+	for len(b) > elementLen && b[0] == 0 {
+		b = b[1:]
+	}
+	if len(b) > elementLen {
+		return e, errElementOverflow
+	}
+	copy(e[elementLen-len(b):], b)
+	return e, nil
+}
+
+func elementFromUint64(u uint64) element {
+	var e element
+	binary.BigEndian.PutUint64(e[elementLen-8:], u)
+	return e
+}
+
+func (e *element) BigInt() *big.Int {
+	return new(big.Int).SetBytes(e[:])
+}
+
+func (e *element) isZero() bool {
+	return *e == element{}
+}
+
+// bytes returns the minimal big-endian encoding of e, matching
+// big.Int.Bytes. The result aliases e.
+func (e *element) bytes() []byte {
+	i := 0
+	for i < elementLen && e[i] == 0 {
+		i++
+	}
+	return e[i:]
+}
+
+func (e element) String() string {
+	return e.BigInt().String()
+}
+
+func bigInts(elements []element) []*big.Int {
+	b := make([]*big.Int, len(elements))
+	for i := range elements {
+		b[i] = elements[i].BigInt()
+	}
+	return b
+}
+
+// hashElements hashes the given elements with fn, converting them to newly
+// allocated big.Int inputs.
+func hashElements(fn HashFn, elements ...element) (element, error) {
+	inputs := make([]*big.Int, len(elements))
+	for i := range elements {
+		inputs[i] = elements[i].BigInt()
+	}
+	h, err := fn(inputs)
+	if err != nil {
+		return element{}, err
+	}
+	return newElement(h)
+}
+
+// hashElementsWith hashes the given elements with fn.
+func hashElementsWith(fn ElementHashFn, elements ...element) (element, error) {
+	inputs := make([][elementLen]byte, len(elements))
+	for i := range elements {
+		inputs[i] = elements[i]
+	}
+	h, err := fn(inputs)
+	return element(h), err
+}
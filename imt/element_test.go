@@ -0,0 +1,159 @@
+package imt
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestElement(t *testing.T) {
+	for _, i := range []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		new(big.Int).Lsh(big.NewInt(1), 200),
+		new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)),
+	} {
+		e, err := newElement(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.BigInt().Cmp(i) != 0 || !bytes.Equal(e.bytes(), i.Bytes()) {
+			t.Fatalf("%s: got %s", i, e)
+		}
+		f, err := elementFromBytes(i.Bytes())
+		if err != nil || f != e {
+			t.Fatalf("%s: elementFromBytes got %s, %v", i, f, err)
+		}
+	}
+	if _, err := newElement(new(big.Int).Lsh(big.NewInt(1), 256)); err == nil {
+		t.Fatal("expected overflow")
+	}
+	if _, err := newElement(big.NewInt(-1)); err == nil {
+		t.Fatal("expected negative value to fail")
+	}
+}
+
+func TestHashInputs(t *testing.T) {
+	// the HashFn may retain its inputs
+	var retained [][]*big.Int
+	var copies [][]string
+	hash := func(inputs []*big.Int) (*big.Int, error) {
+		retained = append(retained, inputs)
+		var c []string
+		for _, i := range inputs {
+			c = append(c, i.String())
+		}
+		copies = append(copies, c)
+		return testHash(inputs)
+	}
+	w := NewTreeWriter(testDB(t).NewTransaction(), 10, fr.Bytes, hash)
+	for _, op := range testOps(20) {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, inputs := range retained {
+		for j, input := range inputs {
+			if input.String() != copies[i][j] {
+				t.Fatalf("call %d: input %d was modified after the call", i, j)
+			}
+		}
+	}
+}
+
+func TestElementHash(t *testing.T) {
+	var calls int
+	hash := func(inputs [][32]byte) ([32]byte, error) {
+		calls++
+		b := make([]*big.Int, len(inputs))
+		for i := range inputs {
+			b[i] = new(big.Int).SetBytes(inputs[i][:])
+		}
+		var e [32]byte
+		h, err := testHash(b)
+		if err != nil {
+			return e, err
+		}
+		h.FillBytes(e[:])
+		return e, nil
+	}
+	ops := testOps(60)
+	a, ap, ar := testBuild(t, ops, 10)
+	for _, opts := range [][]Option{
+		{WithElementHash(hash)},
+		{WithElementHash(hash), WithDeferredHashing(true)},
+		{WithElementHash(hash), WithDeferredHashing(false)},
+	} {
+		calls = 0
+		b, bp, br := testBuild(t, ops, 10, opts...)
+		if calls == 0 {
+			t.Fatal("element hash was not used")
+		}
+		if a != b || ar.Cmp(br) != 0 {
+			t.Fatal("element hash built a different tree")
+		}
+		for i := range bp {
+			if fmt.Sprint(ap[i]) != fmt.Sprint(bp[i]) {
+				t.Fatalf("proof %d: got %v, want %v", i, bp[i], ap[i])
+			}
+		}
+	}
+}
+
+// TestHashKey compares hashKey with the big.Int computation it replaced.
+func TestHashKey(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	one := big.NewInt(1)
+	for levels := uint64(1); levels <= 64; levels++ {
+		tr := &treeReader{levels: levels}
+		for i := 0; i < 200; i++ {
+			level := uint64(r.Int63n(int64(levels + 1)))
+			var index uint64
+			if i > 0 && level < 64 {
+				index = r.Uint64() % (1 << level)
+			} else if i > 0 {
+				index = r.Uint64()
+			}
+			position := new(big.Int).Lsh(one, uint(levels+1))
+			position.Sub(position, new(big.Int).Lsh(one, uint(level+1)))
+			position.Add(position, new(big.Int).SetUint64(index))
+			want := append([]byte{hashKeyPrefix}, position.Bytes()...)
+			if got := tr.hashKey(index, level); !bytes.Equal(got, want) {
+				t.Fatalf("levels %d, level %d, index %d: got %x, want %x", levels, level, index, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	w := NewTreeWriter(testDB(b).NewTransaction(), 32, fr.Bytes, testHash)
+	keys := testKeys(b.N, 9)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Insert(keys[i], big.NewInt(1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProveInclusion(b *testing.B) {
+	w := NewTreeWriter(testDB(b).NewTransaction(), 32, fr.Bytes, testHash)
+	keys := testKeys(1000, 9)
+	for _, k := range keys {
+		if _, err := w.Insert(k, big.NewInt(1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.ProveInclusion(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+var historySizeKey = []byte{historySizeKeyPrefix}
+
+// RootHistory is an append-only Merkle tree of the roots a tree has committed,
+// maintained by writers created WithRootHistory. Leaf i is the hash of the
+// i-th root, and, as in the tree itself, a hash with an empty sibling is
+// passed up unchanged. The history root is the hash of the top hash and the
+// number of roots.
+type RootHistory struct {
+	reader db.Reader
+	levels uint64
+	hash   HashFn
+}
+
+// NewRootHistory reads the root history stored in reader, with the levels it
+// was written with. Tree.ViewHistory returns the history of a Tree.
+func NewRootHistory(reader db.Reader, levels uint64, hash HashFn) *RootHistory {
+	return &RootHistory{
+		reader: reader,
+		levels: levels,
+		hash:   hash,
+	}
+}
+
+func (h *RootHistory) Levels() uint64 {
+	return h.levels
+}
+
+func (h *RootHistory) Root() (*big.Int, error) {
+	size, err := h.Size()
+	if err != nil {
+		return nil, err
+	}
+	root, err := h.root(size)
+	if err != nil {
+		return nil, err
+	}
+	return root.BigInt(), nil
+}
+
+func (h *RootHistory) root(size uint64) (element, error) {
+	top, err := h.getHash(0, 0)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return element{}, err
+	}
+	return hashElements(h.hash, top, elementFromUint64(size))
+}
+
+// Size returns the number of roots in the history.
+func (h *RootHistory) Size() (uint64, error) {
+	return getUint64(h.reader, historySizeKey)
+}
+
+// Prove proves that root is in the history, at the last index it was
+// appended at. It reads the history in several steps, so nothing must commit
+// to the reader meanwhile: use Tree.ProveHistory for a tree that is written
+// to concurrently.
+func (h *RootHistory) Prove(root *big.Int) (RootHistoryProof, error) {
+	r, err := newElement(root)
+	if err != nil {
+		return nil, err
+	}
+	index, err := h.index(r)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, errors.New("root is not in the history")
+	} else if err != nil {
+		return nil, err
+	}
+	size, err := h.Size()
+	if err != nil {
+		return nil, err
+	}
+	historyRoot, err := h.root(size)
+	if err != nil {
+		return nil, err
+	}
+	p := &rootHistoryProof{
+		historyRoot: historyRoot,
+		size:        size,
+		root:        r,
+		index:       index,
+		siblings:    make([]element, h.levels),
+	}
+	for level := h.levels; level > 0; index /= 2 {
+		level--
+		s, err := h.getHash(index^1, level+1)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		p.siblings[level] = s
+	}
+	return p, nil
+}
+
+func (h *RootHistory) index(root element) (uint64, error) {
+	b, err := h.reader.Get(historyIndexKey(root))
+	if err != nil {
+		return 0, err
+	}
+	e, err := elementFromBytes(b)
+	if err != nil {
+		return 0, err
+	}
+	return e.BigInt().Uint64(), nil
+}
+
+func (h *RootHistory) getHash(index, level uint64) (element, error) {
+	b, err := h.reader.Get(positionKey(historyHashKeyPrefix, h.levels, index, level))
+	if err != nil {
+		return element{}, err
+	}
+	return elementFromBytes(b)
+}
+
+// append adds root to the history in tx, unless it is the last root added.
+func (h *RootHistory) append(tx db.Transaction, root element) error {
+	size, err := h.Size()
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		last, err := h.index(root)
+		if err == nil && last == size-1 {
+			return nil
+		} else if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	}
+	if size>>h.levels != 0 {
+		return errors.New("root history is full")
+	}
+
+	c, err := hashElements(h.hash, root)
+	if err != nil {
+		return err
+	}
+	index := size
+	for level := h.levels; ; level-- {
+		if err := tx.Set(positionKey(historyHashKeyPrefix, h.levels, index, level), c.bytes()); err != nil {
+			return err
+		}
+		if level == 0 {
+			break
+		}
+		s, err := h.getHash(index^1, level)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		if !s.isZero() {
+			if index%2 == 0 {
+				c, err = hashElements(h.hash, c, s)
+			} else {
+				c, err = hashElements(h.hash, s, c)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		index /= 2
+	}
+
+	i := elementFromUint64(size)
+	if err := tx.Set(historyIndexKey(root), i.bytes()); err != nil {
+		return err
+	}
+	s := elementFromUint64(size + 1)
+	return tx.Set(historySizeKey, s.bytes())
+}
+
+func historyIndexKey(root element) []byte {
+	return append([]byte{historyIndexKeyPrefix}, root.bytes()...)
+}
+
+// RootHistoryProof proves that Root is the root at Index in a root history.
+type RootHistoryProof interface {
+	HistoryRoot() *big.Int
+	HistorySize() uint64
+	Root() *big.Int
+	Index() uint64
+	Siblings() []*big.Int
+	Valid(h *RootHistory) (bool, error)
+}
+
+type rootHistoryProof struct {
+	historyRoot element
+	size        uint64
+	root        element
+	index       uint64
+	siblings    []element
+}
+
+var _ RootHistoryProof = (*rootHistoryProof)(nil)
+
+func (p *rootHistoryProof) HistoryRoot() *big.Int {
+	return p.historyRoot.BigInt()
+}
+
+func (p *rootHistoryProof) HistorySize() uint64 {
+	return p.size
+}
+
+func (p *rootHistoryProof) Root() *big.Int {
+	return p.root.BigInt()
+}
+
+func (p *rootHistoryProof) Index() uint64 {
+	return p.index
+}
+
+func (p *rootHistoryProof) Siblings() []*big.Int {
+	return bigInts(p.siblings)
+}
+
+func (p *rootHistoryProof) Valid(h *RootHistory) (bool, error) {
+	if p.index >= p.size || uint64(len(p.siblings)) != h.levels {
+		return false, nil
+	}
+	c, err := hashElements(h.hash, p.root)
+	if err != nil {
+		return false, err
+	}
+	index := p.index
+	for level := h.levels; level > 0; index /= 2 {
+		level--
+		if !p.siblings[level].isZero() {
+			if index%2 == 0 {
+				c, err = hashElements(h.hash, c, p.siblings[level])
+			} else {
+				c, err = hashElements(h.hash, p.siblings[level], c)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	c, err = hashElements(h.hash, c, elementFromUint64(p.size))
+	if err != nil {
+		return false, err
+	}
+	return c == p.historyRoot, nil
+}
+
+func (p *rootHistoryProof) String() string {
+	return fmt.Sprintf("RootHistoryProof{HistoryRoot: %s, HistorySize: %d, Root: %s, Index: %d, Siblings: %v}", p.historyRoot, p.size, p.root, p.index, p.siblings)
+}
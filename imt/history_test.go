@@ -0,0 +1,156 @@
+package imt
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestRootHistory(t *testing.T) {
+	const historyLevels = 4
+	tree, err := NewTree(testDB(t), 8, fr.Bytes, testHash, WithRootHistory(historyLevels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []*big.Int
+	for i := int64(1); i <= 10; i++ {
+		err := tree.Update(func(w TreeWriter) error {
+			_, err := w.Insert(big.NewInt(i*10), big.NewInt(i))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, tree.Root())
+		if i == 5 {
+			// the root is unchanged, so not appended again
+			if err := tree.Update(func(TreeWriter) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = tree.ViewHistory(func(h *RootHistory) error {
+		size, err := h.Size()
+		if err != nil {
+			return err
+		}
+		if size != uint64(len(roots)) {
+			t.Fatalf("history size %d, want %d", size, len(roots))
+		}
+		for i, root := range roots {
+			p, err := h.Prove(root)
+			if err != nil {
+				return err
+			}
+			if p.Index() != uint64(i) {
+				t.Fatalf("root %d at index %d", i, p.Index())
+			}
+			if ok, err := p.Valid(h); !ok || err != nil {
+				t.Fatalf("root %d: valid = %v, %v", i, ok, err)
+			}
+		}
+		p, err := h.Prove(roots[3])
+		if err != nil {
+			return err
+		}
+		q := *p.(*rootHistoryProof)
+		q.index = 4
+		if ok, err := q.Valid(h); ok || err != nil {
+			t.Fatalf("proof at another index: valid = %v, %v", ok, err)
+		}
+		q = *p.(*rootHistoryProof)
+		q.size = 3
+		if ok, err := q.Valid(h); ok || err != nil {
+			t.Fatalf("proof beyond the size: valid = %v, %v", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.ProveHistory(big.NewInt(12345)); err == nil {
+		t.Fatal("expected an error for a root not in the history")
+	}
+
+	// the history is full after 2^levels roots
+	for i := int64(1000); ; i++ {
+		err := tree.Update(func(w TreeWriter) error {
+			_, err := w.Insert(big.NewInt(i), big.NewInt(i))
+			return err
+		})
+		if err != nil {
+			break
+		}
+	}
+	if tree.Size() != 1<<historyLevels {
+		t.Fatalf("size %d after the history filled up", tree.Size())
+	}
+
+	tree, err = NewTree(testDB(t), 8, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.ProveHistory(tree.Root()); err == nil {
+		t.Fatal("expected an error for a tree without history")
+	}
+}
+
+func TestRootHistoryConcurrent(t *testing.T) {
+	tree, err := NewTree(testDB(t), 16, fr.Bytes, testHash, WithRootHistory(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update(func(w TreeWriter) error {
+		_, err := w.Insert(big.NewInt(1), big.NewInt(1))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	first := tree.Root()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				err := tree.ViewHistory(func(h *RootHistory) error {
+					p, err := h.Prove(first)
+					if err != nil {
+						return err
+					}
+					ok, err := p.Valid(h)
+					if err == nil && !ok {
+						t.Error("invalid history proof")
+					}
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for _, k := range testKeys(100, 2) {
+		err := tree.Update(func(w TreeWriter) error {
+			_, err := w.Set(k, big.NewInt(1))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
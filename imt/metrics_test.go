@@ -0,0 +1,87 @@
+package imt
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/mdehoog/indexed-merkle-tree/db"
+	"github.com/mdehoog/indexed-merkle-tree/metrics"
+)
+
+// tracer counts the spans started and ended for each operation.
+type tracer struct {
+	*metrics.Registry
+	mu     sync.Mutex
+	starts map[string]int
+	ends   map[string]int
+}
+
+func (t *tracer) StartSpan(op string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.starts[op]++
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.ends[op]++
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := &tracer{
+		Registry: metrics.NewRegistry("imt"),
+		starts:   make(map[string]int),
+		ends:     make(map[string]int),
+	}
+	p, err := pebble.Open(t.TempDir(), &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := db.NewPebble(p, db.WithRecorder(r))
+	defer d.Close()
+
+	tree, err := NewTree(d, 16, fr.Bytes, testHash, WithRecorder(r), WithDeferredHashing(false), WithWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testKeys(20, 9)
+	err = tree.Update(func(w TreeWriter) error {
+		for _, k := range keys {
+			if _, err := w.Insert(k, big.NewInt(1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.ProveExclusion(big.NewInt(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Rebuild(d.NewTransaction(), 16, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Size() != 20 {
+		t.Fatalf("size %d, want 20", r.Size())
+	}
+	for _, c := range []metrics.Counter{metrics.HashCalls, metrics.DBGets, metrics.DBSets, metrics.DBSeeks, metrics.DBDeletes} {
+		if r.Count(c) == 0 {
+			t.Errorf("no %s recorded", c)
+		}
+	}
+	for _, op := range []string{metrics.OpInsert, metrics.OpFlush, metrics.OpCommit, metrics.OpProveExclusion} {
+		if r.starts[op] == 0 || r.starts[op] != r.ends[op] {
+			t.Errorf("%s: %d spans started, %d ended", op, r.starts[op], r.ends[op])
+		}
+	}
+}
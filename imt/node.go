@@ -7,8 +7,15 @@ import (
 	"math/big"
 )
 
+// HashFn hashes field elements. Every call is passed newly allocated inputs,
+// which the function may retain.
 type HashFn func([]*big.Int) (*big.Int, error)
 
+// ElementHashFn computes the same function as a HashFn, on field elements
+// encoded as 32-byte big-endian integers, avoiding the big.Int conversions.
+// The inputs are newly allocated for each call.
+type ElementHashFn func([][32]byte) ([32]byte, error)
+
 type Node interface {
 	Key() *big.Int
 	Index() uint64
@@ -18,24 +25,19 @@ type Node interface {
 }
 
 type node struct {
-	key     *big.Int
+	key     element
 	index   uint64
-	value   *big.Int
-	nextKey *big.Int
+	value   element
+	nextKey element
 }
 
 var _ Node = &node{}
 
 func initialStateNode() *node {
-	return &node{
-		key:     new(big.Int),
-		index:   0,
-		value:   new(big.Int),
-		nextKey: new(big.Int),
-	}
+	return &node{}
 }
 
-func fromBytes(key *big.Int, b []byte) (*node, error) {
+func fromBytes(key element, b []byte) (*node, error) {
 	n := &node{
 		key: key,
 	}
@@ -44,20 +46,27 @@ func fromBytes(key *big.Int, b []byte) (*node, error) {
 	}
 	n.index = binary.BigEndian.Uint64(b)
 	b = b[8:]
-	if len(b) < 1 {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
 		return nil, errors.New("invalid bytes")
 	}
-	n.value = new(big.Int).SetBytes(b[1 : 1+b[0]])
+	var err error
+	n.value, err = elementFromBytes(b[1 : 1+b[0]])
+	if err != nil {
+		return nil, err
+	}
 	b = b[1+b[0]:]
-	if len(b) < 1 {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
 		return nil, errors.New("invalid bytes")
 	}
-	n.nextKey = new(big.Int).SetBytes(b[1 : 1+b[0]])
+	n.nextKey, err = elementFromBytes(b[1 : 1+b[0]])
+	if err != nil {
+		return nil, err
+	}
 	return n, nil
 }
 
 func (n *node) Key() *big.Int {
-	return n.key
+	return n.key.BigInt()
 }
 
 func (n *node) Index() uint64 {
@@ -65,24 +74,32 @@ func (n *node) Index() uint64 {
 }
 
 func (n *node) Value() *big.Int {
-	return n.value
+	return n.value.BigInt()
 }
 
 func (n *node) NextKey() *big.Int {
-	return n.nextKey
+	return n.nextKey.BigInt()
 }
 
 func (n *node) Hash(fn HashFn) (*big.Int, error) {
-	return fn([]*big.Int{n.key, n.value, n.nextKey})
+	h, err := n.hash(fn)
+	if err != nil {
+		return nil, err
+	}
+	return h.BigInt(), nil
+}
+
+func (n *node) hash(fn HashFn) (element, error) {
+	return hashElements(fn, n.key, n.value, n.nextKey)
 }
 
 func (n *node) bytes() []byte {
-	var b []byte
+	vb := n.value.bytes()
+	nkb := n.nextKey.bytes()
+	b := make([]byte, 0, 10+len(vb)+len(nkb))
 	b = binary.BigEndian.AppendUint64(b, n.index)
-	vb := n.value.Bytes()
 	b = append(b, byte(len(vb)))
 	b = append(b, vb...)
-	nkb := n.nextKey.Bytes()
 	b = append(b, byte(len(nkb)))
 	return append(b, nkb...)
 }
@@ -0,0 +1,36 @@
+package imt
+
+import "math/big"
+
+// Mutation is a successful Insert or Update, and its proof. OldValue is nil
+// for inserts.
+type Mutation struct {
+	Key      *big.Int
+	OldValue *big.Int
+	NewValue *big.Int
+	Index    uint64
+	Update   bool
+	Proof    MutateProof
+}
+
+// Observer is notified of the changes made by a writer once they are
+// committed: first each mutation in order, then the commit itself. Observers
+// are called synchronously, and must not use the writer or call Tree.Update.
+type Observer interface {
+	OnMutation(Mutation)
+	OnCommit(root *big.Int, size uint64)
+}
+
+func newMutation(m *mutation, p MutateProof) Mutation {
+	mutation := Mutation{
+		Key:      m.node.Key(),
+		NewValue: m.node.Value(),
+		Index:    m.node.Index(),
+		Update:   m.update,
+		Proof:    p,
+	}
+	if m.update {
+		mutation.OldValue = m.lowNode.Value()
+	}
+	return mutation
+}
@@ -0,0 +1,109 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+type testObserver struct {
+	mutations []Mutation
+	commits   int
+	root      *big.Int
+	size      uint64
+	tree      *Tree
+}
+
+func (o *testObserver) OnMutation(m Mutation) {
+	o.mutations = append(o.mutations, m)
+}
+
+func (o *testObserver) OnCommit(root *big.Int, size uint64) {
+	o.commits++
+	o.root, o.size = root, size
+	if o.tree != nil {
+		// observers may read the tree
+		_ = o.tree.Root()
+	}
+}
+
+func TestObserver(t *testing.T) {
+	ops := testOps(40)
+	_, want, _ := testBuild(t, ops, 16)
+	for _, mode := range []struct {
+		name   string
+		opts   []Option
+		proofs bool
+	}{
+		{"immediate", nil, true},
+		{"deferred", []Option{WithDeferredHashing(false)}, false},
+		{"deferred with proofs", []Option{WithDeferredHashing(true)}, true},
+	} {
+		o := &testObserver{}
+		_, proofs, root := testBuild(t, ops, 16, append(mode.opts, WithObserver(o))...)
+		if len(o.mutations) != len(ops) || o.commits != 1 || o.root.Cmp(root) != 0 || o.size != 40 {
+			t.Fatalf("%s: %d mutations, %d commits, size %d", mode.name, len(o.mutations), o.commits, o.size)
+		}
+		updates := 0
+		for i, m := range o.mutations {
+			if m.Key.Cmp(ops[i].key) != 0 || m.NewValue.Cmp(ops[i].value) != 0 {
+				t.Fatalf("%s: mutation %d out of order", mode.name, i)
+			}
+			if m.Update != (m.OldValue != nil) {
+				t.Fatalf("%s: mutation %d has old value %v", mode.name, i, m.OldValue)
+			}
+			if m.Update {
+				updates++
+			}
+			// observers are given proofs even if the writer does not return them
+			if m.Proof == nil || fmt.Sprint(m.Proof) != fmt.Sprint(want[i]) {
+				t.Fatalf("%s: mutation %d has proof %v, want %v", mode.name, i, m.Proof, want[i])
+			}
+			if mode.proofs && m.Proof != proofs[i] {
+				t.Fatalf("%s: mutation %d has proof %v, writer returned %v", mode.name, i, m.Proof, proofs[i])
+			}
+		}
+		if !mode.proofs && len(proofs) != 0 {
+			t.Fatalf("%s: writer returned %d proofs", mode.name, len(proofs))
+		}
+		if updates != len(ops)-40 {
+			t.Fatalf("%s: %d updates, want %d", mode.name, updates, len(ops)-40)
+		}
+	}
+}
+
+func TestObserverTree(t *testing.T) {
+	o := &testObserver{}
+	tree, err := NewTree(testDB(t), 16, fr.Bytes, testHash, WithObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.tree = tree
+
+	_ = tree.Update(func(w TreeWriter) error {
+		if _, err := w.Insert(big.NewInt(5), big.NewInt(1)); err != nil {
+			return err
+		}
+		return errors.New("discard")
+	})
+	if len(o.mutations) != 0 || o.commits != 0 {
+		t.Fatal("discarded update was observed")
+	}
+
+	err = tree.Update(func(w TreeWriter) error {
+		if _, err := w.Insert(big.NewInt(5), big.NewInt(1)); err != nil {
+			return err
+		}
+		_, err := w.Update(big.NewInt(5), big.NewInt(2))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.mutations) != 2 || o.commits != 1 || o.root.Cmp(tree.Root()) != 0 || o.mutations[1].OldValue.Int64() != 1 {
+		t.Fatalf("got mutations %v", o.mutations)
+	}
+}
@@ -0,0 +1,95 @@
+package imt
+
+import "github.com/mdehoog/indexed-merkle-tree/metrics"
+
+type options struct {
+	deferred       bool
+	deferredProofs bool
+	workers        int
+	cache          *Cache
+	observers      []Observer
+	recorder       metrics.Recorder
+	historyLevels  uint64
+	elementHash    ElementHashFn
+}
+
+type Option func(*options)
+
+// WithDeferredHashing defers hashing until the writer is flushed or committed,
+// at which point every affected internal node is recomputed once. Mutations
+// return a nil MutateProof; if proofs is true, Flush returns the proof of each
+// mutation instead.
+//
+// Proofs are generated by replaying the mutations in memory, which hashes the
+// path of every mutation once, as without deferred hashing, so only the
+// database writes are deferred. Without proofs or observers, each affected node
+// is hashed once, in parallel with WithWorkers.
+//
+// The pending mutations are also flushed when the transaction is committed
+// directly, if it implements db.CommitHooks; see NewTreeWriter.
+func WithDeferredHashing(proofs bool) Option {
+	return func(o *options) {
+		o.deferred = true
+		o.deferredProofs = proofs
+	}
+}
+
+// WithWorkers sets the number of goroutines used to hash deferred mutations
+// when they are flushed without proofs. The HashFn must be safe for concurrent
+// use.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// WithCache reads hashes through the given cache, which must only be shared
+// between readers and writers of the same tree.
+func WithCache(cache *Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithObserver notifies the observer of every mutation and commit made by a
+// writer. Observers are always given the proof of each mutation, so with
+// WithDeferredHashing the mutations are replayed as if proofs were requested.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observers = append(o.observers, observer)
+	}
+}
+
+// WithRecorder records hash calls, operation latencies and the tree size to the
+// given recorder. Database reads and writes are recorded by the database, see
+// db.WithRecorder.
+func WithRecorder(recorder metrics.Recorder) Option {
+	return func(o *options) {
+		o.recorder = recorder
+	}
+}
+
+// WithRootHistory appends the root to a RootHistory of the given levels, stored
+// with the tree, each time a writer commits a new root.
+func WithRootHistory(levels uint64) Option {
+	return func(o *options) {
+		o.historyLevels = levels
+	}
+}
+
+// WithElementHash hashes with fn instead of the HashFn when building the tree
+// and generating proofs. fn must compute the same function as the HashFn, and
+// must be safe for concurrent use if the HashFn is used WithWorkers.
+func WithElementHash(fn ElementHashFn) Option {
+	return func(o *options) {
+		o.elementHash = fn
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{recorder: metrics.Nop}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
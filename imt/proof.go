@@ -14,16 +14,16 @@ type Proof interface {
 }
 
 type proof struct {
-	root     *big.Int
+	root     element
 	size     uint64
-	node     Node
-	siblings []*big.Int
+	node     *node
+	siblings []element
 }
 
 var _ Proof = (*proof)(nil)
 
 func (p *proof) Root() *big.Int {
-	return p.root
+	return p.root.BigInt()
 }
 
 func (p *proof) Size() uint64 {
@@ -35,33 +35,34 @@ func (p *proof) Node() Node {
 }
 
 func (p *proof) Siblings() []*big.Int {
-	return p.siblings
+	return bigInts(p.siblings)
 }
 
 func (p *proof) Valid(t TreeReader) (bool, error) {
-	h, err := p.node.Hash(t.Hash)
+	hash := t.Hash
+	h, err := p.node.hash(hash)
 	if err != nil {
 		return false, err
 	}
 	index := p.node.Index()
 	for level := t.Levels(); level > 0; index /= 2 {
 		level--
-		if p.siblings[level].Cmp(big.NewInt(0)) != 0 {
+		if !p.siblings[level].isZero() {
 			if index%2 == 0 {
-				h, err = t.Hash([]*big.Int{h, p.siblings[level]})
+				h, err = hashElements(hash, h, p.siblings[level])
 			} else {
-				h, err = t.Hash([]*big.Int{p.siblings[level], h})
+				h, err = hashElements(hash, p.siblings[level], h)
 			}
 			if err != nil {
 				return false, err
 			}
 		}
 	}
-	h, err = t.Hash([]*big.Int{h, new(big.Int).SetUint64(p.size)})
+	h, err = hashElements(hash, h, elementFromUint64(p.size))
 	if err != nil {
 		return false, err
 	}
-	return h.Cmp(p.root) == 0, nil
+	return h == p.root, nil
 }
 
 func (p *proof) String() string {
@@ -82,21 +83,21 @@ type MutateProof interface {
 }
 
 type mutateProof struct {
-	oldRoot     *big.Int
+	oldRoot     element
 	oldSize     uint64
-	oldSiblings []*big.Int
-	newRoot     *big.Int
-	node        Node
-	siblings    []*big.Int
-	lowNode     Node // LowNode.Value == OldValue for updates
-	lowSiblings []*big.Int
+	oldSiblings []element
+	newRoot     element
+	node        *node
+	siblings    []element
+	lowNode     *node // LowNode.Value == OldValue for updates
+	lowSiblings []element
 	update      bool
 }
 
 var _ MutateProof = (*mutateProof)(nil)
 
 func (p *mutateProof) OldRoot() *big.Int {
-	return p.oldRoot
+	return p.oldRoot.BigInt()
 }
 
 func (p *mutateProof) OldSize() uint64 {
@@ -104,11 +105,11 @@ func (p *mutateProof) OldSize() uint64 {
 }
 
 func (p *mutateProof) OldSiblings() []*big.Int {
-	return p.oldSiblings
+	return bigInts(p.oldSiblings)
 }
 
 func (p *mutateProof) NewRoot() *big.Int {
-	return p.newRoot
+	return p.newRoot.BigInt()
 }
 
 func (p *mutateProof) Node() Node {
@@ -116,7 +117,7 @@ func (p *mutateProof) Node() Node {
 }
 
 func (p *mutateProof) Siblings() []*big.Int {
-	return p.siblings
+	return bigInts(p.siblings)
 }
 
 func (p *mutateProof) LowNode() Node {
@@ -124,7 +125,7 @@ func (p *mutateProof) LowNode() Node {
 }
 
 func (p *mutateProof) LowSiblings() []*big.Int {
-	return p.lowSiblings
+	return bigInts(p.lowSiblings)
 }
 
 func (p *mutateProof) Update() bool {
@@ -0,0 +1,321 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+	"github.com/mdehoog/indexed-merkle-tree/metrics"
+)
+
+// RangeProof proves the complete set of nodes with keys in [Start, End]: the
+// low node of Start links to the first of Nodes, each node links to the next,
+// and the last links past End, to NextNode if there is one. Siblings is a
+// multiproof of every returned node against the root.
+//
+// A proof cut short by its node limit ends at the last key proven, which
+// Continuation returns; the rest of the range starts after it.
+type RangeProof interface {
+	Root() *big.Int
+	Size() uint64
+	Start() *big.Int
+	End() *big.Int
+	LowNode() Node
+	Nodes() []Node
+	NextNode() Node // nil if the last node is the greatest key
+	Siblings() []*big.Int
+	Continuation() *big.Int // nil if the proof reaches the requested end
+	Valid(t TreeReader) (bool, error)
+}
+
+type rangeProof struct {
+	root     element
+	size     uint64
+	start    element
+	end      element
+	lowNode  *node
+	nodes    []*node
+	nextNode *node
+	siblings []element
+	more     bool
+}
+
+var _ RangeProof = (*rangeProof)(nil)
+
+func (p *rangeProof) Root() *big.Int {
+	return p.root.BigInt()
+}
+
+func (p *rangeProof) Size() uint64 {
+	return p.size
+}
+
+func (p *rangeProof) Start() *big.Int {
+	return p.start.BigInt()
+}
+
+func (p *rangeProof) End() *big.Int {
+	return p.end.BigInt()
+}
+
+func (p *rangeProof) LowNode() Node {
+	return p.lowNode
+}
+
+func (p *rangeProof) Nodes() []Node {
+	return nodes(p.nodes)
+}
+
+func (p *rangeProof) NextNode() Node {
+	if p.nextNode == nil {
+		return nil
+	}
+	return p.nextNode
+}
+
+func (p *rangeProof) Siblings() []*big.Int {
+	return bigInts(p.siblings)
+}
+
+func (p *rangeProof) Continuation() *big.Int {
+	if !p.more {
+		return nil
+	}
+	return p.end.BigInt()
+}
+
+func (p *rangeProof) String() string {
+	return fmt.Sprintf("RangeProof{Root: %s, Size: %d, Start: %s, End: %s, LowNode: %s, Nodes: %v, NextNode: %v, Siblings: %v}", p.root, p.size, p.start, p.end, p.lowNode, p.nodes, p.nextNode, p.siblings)
+}
+
+// all returns the proven nodes in key order.
+func (p *rangeProof) all() []*node {
+	nodes := append([]*node{p.lowNode}, p.nodes...)
+	if p.nextNode != nil {
+		nodes = append(nodes, p.nextNode)
+	}
+	return nodes
+}
+
+func (t *treeReader) ProveRange(start, end *big.Int, maxNodes int) (RangeProof, error) {
+	defer metrics.Trace(t.metrics, metrics.OpProveRange)()
+	if start.Sign() <= 0 || start.Cmp(end) > 0 {
+		return nil, errors.New("invalid range")
+	}
+	if maxNodes <= 0 {
+		return nil, errors.New("invalid node limit")
+	}
+	s, err := t.newElement(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := t.newElement(end)
+	if err != nil {
+		return nil, err
+	}
+	size, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	root, err := t.root(t.hashes, size)
+	if err != nil {
+		return nil, err
+	}
+	p := &rangeProof{
+		root:  root,
+		size:  size,
+		start: s,
+		end:   e,
+	}
+	p.lowNode, err = t.lowNullifierNode(s)
+	if err != nil {
+		return nil, err
+	}
+	n := p.lowNode
+	for !n.nextKey.isZero() {
+		n, err = t.node(n.nextKey)
+		if err != nil {
+			return nil, err
+		}
+		if n.Key().Cmp(end) > 0 {
+			p.nextNode = n
+			break
+		}
+		if len(p.nodes) == maxNodes {
+			// end the proof at the last node, which it still proves complete
+			p.end = p.nodes[len(p.nodes)-1].key
+			p.nextNode = n
+			p.more = true
+			break
+		}
+		p.nodes = append(p.nodes, n)
+	}
+
+	indices := make([]uint64, 0, len(p.nodes)+2)
+	for _, n := range p.all() {
+		indices = append(indices, n.index)
+	}
+	p.siblings, err = t.multiproof(indices)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// multiproof returns the siblings needed to compute the root from the leaves
+// at the given indices, in level order from the leaves up, and in index order
+// within a level. Siblings with no hash are zero.
+func (t *treeReader) multiproof(indices []uint64) ([]element, error) {
+	positions := multiproofPositions(t.levels, indices)
+	siblings := make([]element, len(positions))
+	for i, p := range positions {
+		h, err := t.hashes.getHash(p.index, p.level)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		siblings[i] = h
+	}
+	return siblings, nil
+}
+
+// multiproofPositions returns the positions of the siblings in a multiproof
+// of the leaves at the given indices, in the order of multiproof.
+func multiproofPositions(levels uint64, indices []uint64) []hashPosition {
+	var positions []hashPosition
+	known := uniqueSorted(indices)
+	for level := levels; level > 0; level-- {
+		for i, index := range known {
+			if index%2 == 0 && i+1 < len(known) && known[i+1] == index+1 {
+				continue
+			}
+			if index%2 == 1 && i > 0 && known[i-1] == index-1 {
+				continue
+			}
+			positions = append(positions, hashPosition{index: index ^ 1, level: level})
+		}
+		known = parents(known)
+	}
+	return positions
+}
+
+func (p *rangeProof) Valid(t TreeReader) (bool, error) {
+	if p.start.isZero() || p.start.BigInt().Cmp(p.end.BigInt()) > 0 {
+		return false, nil
+	}
+	start, end := p.start.BigInt(), p.end.BigInt()
+
+	// the nodes must be linked in order, and exactly cover the range
+	if p.lowNode.Key().Cmp(start) >= 0 {
+		return false, nil
+	}
+	prev := p.lowNode
+	for _, n := range p.nodes {
+		if n.key != prev.nextKey || n.Key().Cmp(start) < 0 || n.Key().Cmp(end) > 0 {
+			return false, nil
+		}
+		prev = n
+	}
+	if prev.nextKey.isZero() {
+		if p.nextNode != nil {
+			return false, nil
+		}
+	} else if prev.NextKey().Cmp(end) <= 0 || p.nextNode == nil || p.nextNode.key != prev.nextKey {
+		return false, nil
+	}
+
+	// every node must be a leaf of the root
+	levels := t.Levels()
+	leaves := make(map[uint64]element)
+	for _, n := range p.all() {
+		if n.index>>levels != 0 || n.index > p.size {
+			return false, nil
+		}
+		if _, ok := leaves[n.index]; ok {
+			return false, nil
+		}
+		h, err := n.hash(t.Hash)
+		if err != nil {
+			return false, err
+		}
+		leaves[n.index] = h
+	}
+	root, ok, err := multiproofRoot(t.Hash, levels, leaves, p.siblings)
+	if err != nil || !ok {
+		return false, err
+	}
+	h, err := hashElements(t.Hash, root, elementFromUint64(p.size))
+	if err != nil {
+		return false, err
+	}
+	return h == p.root, nil
+}
+
+// multiproofRoot computes the top hash from the leaves and a multiproof
+// returned by multiproof, returning false if the multiproof has the wrong
+// number of siblings.
+func multiproofRoot(hash HashFn, levels uint64, leaves map[uint64]element, siblings []element) (element, bool, error) {
+	known := make([]uint64, 0, len(leaves))
+	for index := range leaves {
+		known = append(known, index)
+	}
+	known = uniqueSorted(known)
+	hashes := leaves
+	for level := levels; level > 0; level-- {
+		next := make(map[uint64]element, len(known))
+		for i := 0; i < len(known); i++ {
+			index := known[i]
+			h := hashes[index]
+			var sibling element
+			if index%2 == 0 && i+1 < len(known) && known[i+1] == index+1 {
+				sibling = hashes[index+1]
+				i++
+			} else {
+				if len(siblings) == 0 {
+					return element{}, false, nil
+				}
+				sibling, siblings = siblings[0], siblings[1:]
+			}
+			if !sibling.isZero() {
+				var err error
+				if index%2 == 0 {
+					h, err = hashElements(hash, h, sibling)
+				} else {
+					h, err = hashElements(hash, sibling, h)
+				}
+				if err != nil {
+					return element{}, false, err
+				}
+			}
+			next[index/2] = h
+		}
+		hashes = next
+		known = parents(known)
+	}
+	if len(siblings) != 0 {
+		return element{}, false, nil
+	}
+	return hashes[0], true, nil
+}
+
+func uniqueSorted(indices []uint64) []uint64 {
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	unique := indices[:0]
+	for _, index := range indices {
+		if len(unique) == 0 || index != unique[len(unique)-1] {
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
+
+func parents(indices []uint64) []uint64 {
+	p := make([]uint64, 0, len(indices))
+	for _, index := range indices {
+		if len(p) == 0 || p[len(p)-1] != index/2 {
+			p = append(p, index/2)
+		}
+	}
+	return p
+}
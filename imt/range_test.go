@@ -0,0 +1,147 @@
+package imt
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestRangeProof(t *testing.T) {
+	w := NewTreeWriter(testDB(t).NewTransaction(), 6, fr.Bytes, testHash)
+	b := big.NewInt
+
+	p, err := w.ProveRange(b(1), b(100), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.Valid(w); err != nil || !ok {
+		t.Fatalf("empty tree: valid %v, %v", ok, err)
+	}
+
+	for k := int64(10); k <= 300; k += 10 {
+		if _, err := w.Insert(b(k), b(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range [][3]int64{
+		{1, 5, 0}, {1, 10, 1}, {15, 95, 8}, {10, 300, 30}, {295, 1000, 1},
+		{301, 1000, 0}, {300, 300, 1}, {11, 19, 0}, {1, 1000, 30},
+	} {
+		p, err := w.ProveRange(b(c[0]), b(c[1]), 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Nodes()) != int(c[2]) || p.Continuation() != nil {
+			t.Fatalf("%v: got %d nodes, continuation %v", c, len(p.Nodes()), p.Continuation())
+		}
+		if ok, err := p.Valid(w); err != nil || !ok {
+			t.Fatalf("%v: valid %v, %v", c, ok, err)
+		}
+
+		rp := p.(*rangeProof)
+		var tampered []rangeProof
+		if len(rp.nodes) > 1 {
+			// omit a node, or the last node
+			q := *rp
+			q.nodes = append(append([]*node{}, rp.nodes[:1]...), rp.nodes[2:]...)
+			tampered = append(tampered, q)
+			q = *rp
+			q.nodes = rp.nodes[:len(rp.nodes)-1]
+			tampered = append(tampered, q)
+		}
+		if len(rp.siblings) > 0 {
+			// change or drop a sibling
+			q := *rp
+			q.siblings = append([]element{}, rp.siblings...)
+			q.siblings[0][31] ^= 1
+			tampered = append(tampered, q)
+			q = *rp
+			q.siblings = rp.siblings[1:]
+			tampered = append(tampered, q)
+		}
+		if len(rp.nodes) > 0 {
+			// change a value
+			q := *rp
+			n := *rp.nodes[0]
+			n.value[31] ^= 1
+			q.nodes = append([]*node{&n}, rp.nodes[1:]...)
+			tampered = append(tampered, q)
+		}
+		if rp.nextNode != nil && !rp.nextNode.key.isZero() {
+			// extend the range to the unlisted next node
+			q := *rp
+			q.end = rp.nextNode.key
+			tampered = append(tampered, q)
+		}
+		for i := range tampered {
+			if ok, _ := tampered[i].Valid(w); ok {
+				t.Fatalf("%v: tampered proof %d accepted", c, i)
+			}
+		}
+	}
+
+	if _, err := w.ProveRange(b(5), b(4), 10); err == nil {
+		t.Fatal("expected empty range to fail")
+	}
+	if _, err := w.ProveRange(b(1), b(100), 0); err == nil {
+		t.Fatal("expected no node limit to fail")
+	}
+}
+
+func TestRangeProofPages(t *testing.T) {
+	w := NewTreeWriter(testDB(t).NewTransaction(), 6, fr.Bytes, testHash)
+	b := big.NewInt
+	for k := int64(10); k <= 300; k += 10 {
+		if _, err := w.Insert(b(k), b(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []int64
+	start, pages := b(5), 0
+	for {
+		p, err := w.ProveRange(start, b(295), 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if ok, err := p.Valid(w); err != nil || !ok {
+			t.Fatalf("page %d: valid %v, %v", pages, ok, err)
+		}
+		if len(p.Nodes()) > 7 {
+			t.Fatalf("page %d: got %d nodes", pages, len(p.Nodes()))
+		}
+		for _, n := range p.Nodes() {
+			keys = append(keys, n.Key().Int64())
+		}
+		c := p.Continuation()
+		if c == nil {
+			if p.End().Int64() != 295 {
+				t.Fatalf("page %d: ends at %s", pages, p.End())
+			}
+			break
+		}
+		if c.Cmp(p.End()) != 0 || c.Cmp(p.Nodes()[len(p.Nodes())-1].Key()) != 0 {
+			t.Fatalf("page %d: continuation %s is not the last key proven", pages, c)
+		}
+		start = new(big.Int).Add(c, b(1))
+	}
+	if pages != 5 || len(keys) != 29 {
+		t.Fatalf("got %d keys in %d pages", len(keys), pages)
+	}
+	for i, k := range keys {
+		if k != int64(i+1)*10 {
+			t.Fatalf("key %d is %d", i, k)
+		}
+	}
+
+	// a range of exactly the limit is complete
+	p, err := w.ProveRange(b(10), b(70), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Nodes()) != 7 || p.Continuation() != nil {
+		t.Fatalf("got %d nodes, continuation %v", len(p.Nodes()), p.Continuation())
+	}
+}
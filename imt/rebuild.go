@@ -0,0 +1,96 @@
+package imt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// Rebuild discards every stored hash and recomputes them from the stored
+// nodes, using the given number of levels and hash function, which may differ
+// from those the tree was built with. It recovers trees whose hashes are lost
+// or corrupt, and migrates trees between hash configurations. Any Cache used
+// with the tree must be discarded, and is not used by the returned writer.
+//
+// The leaf hashes are written in a single scan of the nodes in key order, and
+// each level above is then computed from the level below, so only the
+// transaction holds the rebuilt hashes in memory.
+func Rebuild(tx db.Transaction, levels, feLen uint64, hash HashFn, opts ...Option) (TreeWriter, error) {
+	o := newOptions(opts)
+	o.cache = nil
+	t := newTreeWriter(tx, levels, feLen, hash, o)
+	size, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	if !t.inCapacity(size) {
+		return nil, errors.New("tree is over capacity")
+	}
+
+	err = db.DeleteRange(tx, []byte{hashKeyPrefix}, []byte{hashKeyPrefix + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	var count uint64
+	err = t.forEachNodeDescending(func(k, v []byte) error {
+		key, err := nodeKeyBytesToKey(k)
+		if err != nil {
+			return err
+		}
+		n, err := fromBytes(key, v)
+		if err != nil {
+			return err
+		}
+		if n.index > size {
+			return fmt.Errorf("node %s has index %d, size is %d", n.key, n.index, size)
+		}
+		// the hashes were deleted, so an existing leaf hash is a duplicate
+		if _, err := t.getHash(n.index, levels); err == nil {
+			return fmt.Errorf("node %s has duplicate index %d", n.key, n.index)
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		h, err := t.hashNode(n)
+		if err != nil {
+			return err
+		}
+		count++
+		return t.setHash(n.index, levels, h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 && size == 0 {
+		return t, nil
+	}
+	if count != size+1 {
+		return nil, fmt.Errorf("found %d nodes, size is %d", count, size)
+	}
+
+	for level := levels; level > 0; level-- {
+		// shifting by 64 or more bits gives 0 in trees of 64 or more levels
+		last := size >> (levels - level)
+		// iterate over pairs, as index+2 could overflow
+		for pair := uint64(0); pair <= last/2; pair++ {
+			h, err := t.getHash(pair*2, level)
+			if err != nil {
+				return nil, err
+			}
+			if pair*2+1 <= last {
+				right, err := t.getHash(pair*2+1, level)
+				if err != nil {
+					return nil, err
+				}
+				if h, err = t.hashElements(h, right); err != nil {
+					return nil, err
+				}
+			}
+			if err := t.setHash(pair, level-1, h); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,109 @@
+package imt
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// deleterTransaction hides every method of a transaction but those of
+// db.Transaction and db.Deleter.
+type deleterTransaction struct {
+	db.Transaction
+	db.Deleter
+}
+
+func TestRebuild(t *testing.T) {
+	ops := testOps(40)
+	want, _, _ := testBuild(t, ops, 10)
+	want16, _, _ := testBuild(t, ops, 16)
+
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash)
+	for _, op := range ops {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt a hash, delete the top hash, and add a stray hash
+	reader := NewTreeReader(d, 10, fr.Bytes, testHash).(*treeReader)
+	for _, err := range []error{
+		p.Set(reader.hashKey(1, 5), []byte{1, 2, 3}, pebble.Sync),
+		p.Delete(reader.hashKey(0, 0), pebble.Sync),
+		p.Set(reader.hashKey(500, 10), []byte{1, 2, 3}, pebble.Sync),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := Rebuild(d.NewTransaction(), 10, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if testDump(t, p) != want {
+		t.Fatal("rebuilt tree differs")
+	}
+
+	w, err = Rebuild(d.NewTransaction(), 16, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if testDump(t, p) != want16 {
+		t.Fatal("tree rebuilt with 16 levels differs")
+	}
+
+	if _, err := Rebuild(d.NewTransaction(), 5, fr.Bytes, testHash); err == nil {
+		t.Fatal("expected capacity error")
+	}
+}
+
+func TestRebuildDeletes(t *testing.T) {
+	ops := testOps(40)
+	want, _, _ := testBuild(t, ops, 10)
+	want16, _, _ := testBuild(t, ops, 16)
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 16, fr.Bytes, testHash)
+	for _, op := range ops {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if testDump(t, p) != want16 {
+		t.Fatal("tree differs")
+	}
+
+	tx := d.NewTransaction()
+	if _, err := Rebuild(struct{ db.Transaction }{tx}, 10, fr.Bytes, testHash); err == nil {
+		t.Fatal("expected a transaction without deletes to fail")
+	}
+	tx.Discard()
+
+	// the hashes of 16 levels are deleted one at a time
+	tx = d.NewTransaction()
+	w, err := Rebuild(deleterTransaction{tx, tx.(db.Deleter)}, 10, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if testDump(t, p) != want {
+		t.Fatal("rebuilt tree differs")
+	}
+}
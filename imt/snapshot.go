@@ -0,0 +1,416 @@
+package imt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// Snapshot format, version 1. All integers are big-endian, and all field
+// elements are 32 bytes big-endian.
+//
+//	magic    [4]byte  "IMTS"
+//	version  uint8    1
+//	levels   uint64
+//	feLen    uint64
+//	size     uint64
+//	root     element
+//	nodes    size+1 records of key, value, nextKey elements, in index order
+//	checksum [32]byte SHA-256 of all preceding bytes
+const snapshotVersion = 1
+
+var snapshotMagic = [4]byte{'I', 'M', 'T', 'S'}
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint8
+	Levels  uint64
+	FeLen   uint64
+	Size    uint64
+	Root    element
+}
+
+// exportWindow is the number of nodes held in memory while walking the nodes
+// in index order.
+var exportWindow uint64 = 1 << 16
+
+// Export writes a snapshot of the tree to w. The nodes are stored by key, so
+// Export walks the linked list once per window of exportWindow indices, holding
+// only that window's nodes in memory: a tree of n nodes costs about
+// n*n/exportWindow node reads.
+func (t *treeReader) Export(w io.Writer) error {
+	size, err := t.Size()
+	if err != nil {
+		return err
+	}
+	root, err := t.root(t.hashes, size)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, checksum))
+	err = binary.Write(bw, binary.BigEndian, snapshotHeader{
+		Magic:   snapshotMagic,
+		Version: snapshotVersion,
+		Levels:  t.levels,
+		FeLen:   t.feLen,
+		Size:    size,
+		Root:    root,
+	})
+	if err != nil {
+		return err
+	}
+	err = t.forEachNodeByIndex(size, func(n *node) error {
+		_, err := bw.Write(n.record())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err = w.Write(checksum.Sum(nil))
+	return err
+}
+
+// forEachNodeByIndex walks the linked list of nodes once per window of
+// exportWindow indices, calling fn with each node in index order.
+func (t *treeReader) forEachNodeByIndex(size uint64, fn func(*node) error) error {
+	if size == 0 {
+		return nil
+	}
+	if !t.inCapacity(size) {
+		return fmt.Errorf("size %d exceeds the capacity of the tree", size)
+	}
+	window := make([]*node, min(exportWindow, size+1))
+	for start := uint64(0); start <= size; start += uint64(len(window)) {
+		end := min(start+uint64(len(window)), size+1)
+		clear(window)
+		n, err := t.node(element{})
+		if err != nil {
+			return err
+		}
+		for count := uint64(0); ; count++ {
+			if count > size || n.index > size {
+				return fmt.Errorf("corrupt node list at index %d", n.index)
+			}
+			if n.index >= start && n.index < end {
+				if window[n.index-start] != nil {
+					return fmt.Errorf("corrupt node list at index %d", n.index)
+				}
+				window[n.index-start] = n
+			}
+			if n.nextKey.isZero() {
+				break
+			}
+			n, err = t.node(n.nextKey)
+			if err != nil {
+				return err
+			}
+		}
+		for i, n := range window[:end-start] {
+			if n == nil {
+				return fmt.Errorf("missing node at index %d", start+uint64(i))
+			}
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *treeWriter) Export(w io.Writer) error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	return t.treeReader.Export(w)
+}
+
+// Import populates an empty tree from a snapshot written by Export. The
+// snapshot is first staged in the database and verified: its checksum, that
+// the nodes form a single sorted linked list from key 0, and that its root
+// matches the header. Only then are the nodes and hashes written, hashing the
+// tree a second time. Both passes are committed in transactions of bounded
+// size, like BulkLoad, with the size committed last. An import that fails
+// during verification leaves the tree empty, and one that fails afterwards
+// leaves a partially imported tree, which must be discarded.
+func Import(database db.Database, levels, feLen uint64, hash HashFn, r io.Reader, opts ...Option) error {
+	o := newOptions(opts)
+	t := newTreeReader(database, levels, feLen, hash, o)
+	size, err := t.Size()
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		return errors.New("import requires an empty tree")
+	}
+	size, err = t.stage(database, r)
+	if err != nil {
+		_ = clearStaged(database)
+		return err
+	}
+	return load(database, levels, feLen, hash, size, o)
+}
+
+// stage writes the nodes of a snapshot to the staging area, indexed by both
+// index and key, and verifies the snapshot, returning its size.
+func (t *treeReader) stage(database db.Database, r io.Reader) (uint64, error) {
+	tx := database.NewTransaction()
+	defer func() {
+		tx.Discard()
+	}()
+	// clear any nodes staged by an interrupted import
+	err := db.DeleteRange(tx, []byte{stagedIndexKeyPrefix}, []byte{stagedKeyKeyPrefix + 1})
+	if err != nil {
+		return 0, err
+	}
+
+	checksum := sha256.New()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, checksum)
+	var header snapshotHeader
+	err = binary.Read(tr, binary.BigEndian, &header)
+	if err != nil {
+		return 0, err
+	}
+	if header.Magic != snapshotMagic {
+		return 0, errors.New("not a tree snapshot")
+	}
+	if header.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if header.Levels != t.levels || header.FeLen != t.feLen {
+		return 0, fmt.Errorf("snapshot has %d levels and feLen %d, tree has %d and %d", header.Levels, header.FeLen, t.levels, t.feLen)
+	}
+	if !t.inCapacity(header.Size) {
+		return 0, fmt.Errorf("snapshot size %d exceeds the capacity of the tree", header.Size)
+	}
+
+	// the hashes are only computed to check the root
+	top, err := t.hashNode(initialStateNode())
+	if err != nil {
+		return 0, err
+	}
+	b := newBuilder(t.levels, t.hashElements, func(index, level uint64, h element) error {
+		if level == 0 {
+			top = h
+		}
+		return nil
+	})
+	for index := uint64(0); header.Size > 0 && index <= header.Size; index++ {
+		record := make([]byte, 3*elementLen)
+		if _, err := io.ReadFull(tr, record); err != nil {
+			return 0, err
+		}
+		n := nodeFromRecord(index, record)
+		if !t.fits(n.key) || !t.fits(n.value) || !t.fits(n.nextKey) {
+			return 0, fmt.Errorf("snapshot node at index %d does not fit in %d bytes", index, t.feLen)
+		}
+		if index == 0 && !n.key.isZero() {
+			return 0, errors.New("snapshot node at index 0 does not have key 0")
+		}
+		_, err := tx.Get(stagedKeyKey(n.key))
+		if err == nil {
+			return 0, fmt.Errorf("snapshot has duplicate key %s", n.key)
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return 0, err
+		}
+		if err := tx.Set(stagedKeyKey(n.key), binary.BigEndian.AppendUint64(nil, index)); err != nil {
+			return 0, err
+		}
+		if err := tx.Set(stagedIndexKey(index), record); err != nil {
+			return 0, err
+		}
+		h, err := t.hashNode(n)
+		if err != nil {
+			return 0, err
+		}
+		if err := b.pushLeaf(index, h); err != nil {
+			return 0, err
+		}
+		if (index+1)%bulkLoadBatchSize == 0 {
+			if err := tx.Commit(); err != nil {
+				return 0, err
+			}
+			tx = database.NewTransaction()
+		}
+	}
+	if err := b.finish(); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, sum); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(sum, checksum.Sum(nil)) {
+		return 0, errors.New("snapshot checksum mismatch")
+	}
+	err = checkList(header.Size, func(key element) (*node, error) {
+		return stagedNode(database, key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	root, err := t.hashElements(top, elementFromUint64(header.Size))
+	if err != nil {
+		return 0, err
+	}
+	if root != header.Root {
+		return 0, errors.New("snapshot root mismatch")
+	}
+	return header.Size, nil
+}
+
+// load writes the staged nodes of a verified snapshot of the given size, and
+// their hashes, to the tree.
+func load(database db.Database, levels, feLen uint64, hash HashFn, size uint64, o options) error {
+	// the batches are not states of the tree, so they are not observed or
+	// recorded in the history
+	batch := o
+	batch.observers = nil
+	batch.historyLevels = 0
+	t := newTreeWriter(database.NewTransaction(), levels, feLen, hash, batch)
+	defer func() {
+		t.tx.Discard()
+	}()
+	if size > 0 {
+		b := newBuilder(levels, t.hashElements, func(index, level uint64, h element) error {
+			return t.setHash(index, level, h)
+		})
+		for index := uint64(0); index <= size; index++ {
+			n, err := stagedNodeAt(database, index)
+			if err != nil {
+				return err
+			}
+			if err := t.load(b, n); err != nil {
+				return err
+			}
+			if (index+1)%bulkLoadBatchSize == 0 {
+				if err := t.Commit(); err != nil {
+					return err
+				}
+				t = newTreeWriter(database.NewTransaction(), levels, feLen, hash, batch)
+			}
+		}
+		if err := b.finish(); err != nil {
+			return err
+		}
+		if err := t.Commit(); err != nil {
+			return err
+		}
+	}
+
+	t = newTreeWriter(database.NewTransaction(), levels, feLen, hash, o)
+	if size > 0 {
+		if err := t.setSize(size); err != nil {
+			return err
+		}
+	}
+	err := db.DeleteRange(t.tx, []byte{stagedIndexKeyPrefix}, []byte{stagedKeyKeyPrefix + 1})
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// clearStaged deletes the staged nodes of a failed import.
+func clearStaged(database db.Database) error {
+	tx := database.NewTransaction()
+	defer tx.Discard()
+	err := db.DeleteRange(tx, []byte{stagedIndexKeyPrefix}, []byte{stagedKeyKeyPrefix + 1})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func stagedIndexKey(index uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{stagedIndexKeyPrefix}, index)
+}
+
+func stagedKeyKey(key element) []byte {
+	return append([]byte{stagedKeyKeyPrefix}, key[:]...)
+}
+
+// stagedNode reads the staged node with the given key.
+func stagedNode(reader db.Reader, key element) (*node, error) {
+	i, err := reader.Get(stagedKeyKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(i) != 8 {
+		return nil, errors.New("corrupt staged node")
+	}
+	index := binary.BigEndian.Uint64(i)
+	return stagedNodeAt(reader, index)
+}
+
+// stagedNodeAt reads the staged node at the given index.
+func stagedNodeAt(reader db.Reader, index uint64) (*node, error) {
+	record, err := reader.Get(stagedIndexKey(index))
+	if err != nil {
+		return nil, err
+	}
+	if len(record) != 3*elementLen {
+		return nil, errors.New("corrupt staged node")
+	}
+	return nodeFromRecord(index, record), nil
+}
+
+// record returns the snapshot record of n: its key, value and next key.
+func (n *node) record() []byte {
+	b := make([]byte, 0, 3*elementLen)
+	b = append(b, n.key[:]...)
+	b = append(b, n.value[:]...)
+	return append(b, n.nextKey[:]...)
+}
+
+func nodeFromRecord(index uint64, record []byte) *node {
+	n := &node{index: index}
+	copy(n.key[:], record)
+	copy(n.value[:], record[elementLen:])
+	copy(n.nextKey[:], record[2*elementLen:])
+	return n
+}
+
+// checkList checks that following the next keys from key 0 visits size+1
+// nodes in increasing key order, ending with a next key of 0. As the keys are
+// unique, this is every node.
+func checkList(size uint64, get func(key element) (*node, error)) error {
+	if size == 0 {
+		return nil
+	}
+	n, err := get(element{})
+	if err != nil {
+		return err
+	}
+	for count := uint64(0); count < size; count++ {
+		if bytes.Compare(n.nextKey[:], n.key[:]) <= 0 {
+			return fmt.Errorf("snapshot node %s links to %s, out of order", n.key, n.nextKey)
+		}
+		next, err := get(n.nextKey)
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("snapshot node %s links to missing key %s", n.key, n.nextKey)
+		} else if err != nil {
+			return err
+		}
+		n = next
+	}
+	if !n.nextKey.isZero() {
+		return fmt.Errorf("snapshot node %s is last but links to %s", n.key, n.nextKey)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package imt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestSnapshot(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 60} {
+		p, d := testPebble(t)
+		w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash, WithDeferredHashing(false))
+		for _, op := range testOps(n) {
+			if _, err := w.Set(op.key, op.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := w.Export(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		p2, d2 := testPebble(t)
+		if err := Import(d2, 10, fr.Bytes, testHash, bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if testDump(t, p2) != testDump(t, p) {
+			t.Fatalf("%d keys: imported tree differs", n)
+		}
+
+		// nothing is written to the tree before the snapshot is verified
+		corrupt := bytes.Clone(buf.Bytes())
+		corrupt[len(corrupt)-1] ^= 1
+		p3, d3 := testPebble(t)
+		if err := Import(d3, 10, fr.Bytes, testHash, bytes.NewReader(corrupt)); err == nil {
+			t.Fatalf("%d keys: expected corrupt snapshot to fail", n)
+		}
+		if dump := testDump(t, p3); dump != "" {
+			t.Fatalf("%d keys: failed import wrote %s", n, dump)
+		}
+	}
+}
+
+func TestSnapshotBatches(t *testing.T) {
+	defer func(window, batch uint64) {
+		exportWindow, bulkLoadBatchSize = window, batch
+	}(exportWindow, bulkLoadBatchSize)
+	exportWindow, bulkLoadBatchSize = 7, 5
+
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), 10, fr.Bytes, testHash)
+	for _, op := range testOps(60) {
+		if _, err := w.Set(op.key, op.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewTreeReader(d, 10, fr.Bytes, testHash).Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p2, d2 := testPebble(t)
+	if err := Import(d2, 10, fr.Bytes, testHash, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if testDump(t, p2) != testDump(t, p) {
+		t.Fatal("imported tree differs")
+	}
+}
+
+func TestSnapshotImportLongKey(t *testing.T) {
+	// a key longer than feLen must be rejected rather than stored
+	snapshot := testSnapshot(2, 4, [][3]int64{{0, 0, 1 << 40}, {1 << 40, 1, 0}})
+	err := Import(testDB(t), 2, 4, testHash, bytes.NewReader(snapshot))
+	if err == nil || !strings.Contains(err.Error(), "does not fit") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+// testSnapshot encodes a snapshot of nodes given as key, value, nextKey, with
+// a valid checksum but an arbitrary root.
+func testSnapshot(levels, feLen uint64, nodes [][3]int64) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, snapshotHeader{
+		Magic:   snapshotMagic,
+		Version: snapshotVersion,
+		Levels:  levels,
+		FeLen:   feLen,
+		Size:    uint64(len(nodes) - 1),
+	})
+	for _, n := range nodes {
+		for _, v := range n {
+			e, _ := newElement(big.NewInt(v))
+			buf.Write(e[:])
+		}
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+func TestSnapshotImportCorruptList(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		nodes [][3]int64
+		err   string
+	}{
+		{"unsorted", [][3]int64{{0, 0, 20}, {20, 1, 10}, {10, 1, 0}}, "out of order"},
+		{"duplicate key", [][3]int64{{0, 0, 5}, {5, 1, 0}, {5, 1, 0}}, "duplicate key"},
+		{"first key", [][3]int64{{1, 0, 5}, {5, 1, 0}}, "index 0"},
+		{"missing key", [][3]int64{{0, 0, 5}, {5, 1, 7}, {6, 1, 0}}, "missing key"},
+		{"unterminated", [][3]int64{{0, 0, 5}, {5, 1, 9}}, "is last"},
+		{"two lists", [][3]int64{{0, 0, 5}, {5, 1, 0}, {3, 1, 0}}, "out of order"},
+		{"over capacity", [][3]int64{{0, 0, 1}, {1, 0, 2}, {2, 0, 3}, {3, 0, 4}, {4, 0, 0}}, "capacity"},
+	} {
+		err := Import(testDB(t), 2, fr.Bytes, testHash, bytes.NewReader(testSnapshot(2, fr.Bytes, c.nodes)))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
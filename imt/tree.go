@@ -0,0 +1,192 @@
+package imt
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/mdehoog/indexed-merkle-tree/db"
+)
+
+// Tree is a concurrency-safe handle to a tree stored in a db.Database. Writers
+// are serialised, and any number of readers can generate proofs against the
+// last committed state while a writer is mutating the tree.
+type Tree struct {
+	db     db.Database
+	levels uint64
+	feLen  uint64
+	hash   HashFn
+	opts   []Option
+
+	// writeMu serialises writers. commitMu is held for reading while proofs
+	// are generated, and for writing while a transaction is committed, so
+	// readers never observe a partially committed state.
+	writeMu  sync.Mutex
+	commitMu sync.RWMutex
+	root     *big.Int
+	size     uint64
+}
+
+func NewTree(database db.Database, levels, feLen uint64, hash HashFn, opts ...Option) (*Tree, error) {
+	t := &Tree{
+		db:     database,
+		levels: levels,
+		feLen:  feLen,
+		hash:   hash,
+		opts:   opts,
+	}
+	r := t.reader()
+	root, err := r.Root()
+	if err != nil {
+		return nil, err
+	}
+	size, err := r.Size()
+	if err != nil {
+		return nil, err
+	}
+	t.root = root
+	t.size = size
+	return t, nil
+}
+
+func (t *Tree) reader() TreeReader {
+	return NewTreeReader(t.db, t.levels, t.feLen, t.hash, t.opts...)
+}
+
+func (t *Tree) Levels() uint64 {
+	return t.levels
+}
+
+// Root returns the root of the last committed state.
+func (t *Tree) Root() *big.Int {
+	t.commitMu.RLock()
+	defer t.commitMu.RUnlock()
+	return t.root
+}
+
+// Size returns the size of the last committed state.
+func (t *Tree) Size() uint64 {
+	t.commitMu.RLock()
+	defer t.commitMu.RUnlock()
+	return t.size
+}
+
+// View calls fn with a reader over the last committed state. The reader must
+// not be used after fn returns.
+func (t *Tree) View(fn func(TreeReader) error) error {
+	t.commitMu.RLock()
+	defer t.commitMu.RUnlock()
+	return fn(t.reader())
+}
+
+func (t *Tree) Get(key *big.Int) (value *big.Int, err error) {
+	err = t.View(func(r TreeReader) error {
+		value, err = r.Get(key)
+		return err
+	})
+	return
+}
+
+func (t *Tree) ProveInclusion(key *big.Int) (p Proof, err error) {
+	err = t.View(func(r TreeReader) error {
+		p, err = r.ProveInclusion(key)
+		return err
+	})
+	return
+}
+
+func (t *Tree) ProveExclusion(key *big.Int) (p Proof, err error) {
+	err = t.View(func(r TreeReader) error {
+		p, err = r.ProveExclusion(key)
+		return err
+	})
+	return
+}
+
+func (t *Tree) ProveRangeExclusion(start, end *big.Int) (p Proof, err error) {
+	err = t.View(func(r TreeReader) error {
+		p, err = r.ProveRangeExclusion(start, end)
+		return err
+	})
+	return
+}
+
+func (t *Tree) ProveRange(start, end *big.Int, maxNodes int) (p RangeProof, err error) {
+	err = t.View(func(r TreeReader) error {
+		p, err = r.ProveRange(start, end, maxNodes)
+		return err
+	})
+	return
+}
+
+// ViewHistory calls fn with the root history of the last committed state. The
+// history must not be used after fn returns. The tree must have been created
+// WithRootHistory.
+func (t *Tree) ViewHistory(fn func(*RootHistory) error) error {
+	levels := newOptions(t.opts).historyLevels
+	if levels == 0 {
+		return errors.New("tree has no root history")
+	}
+	t.commitMu.RLock()
+	defer t.commitMu.RUnlock()
+	return fn(NewRootHistory(t.db, levels, t.hash))
+}
+
+func (t *Tree) ProveHistory(root *big.Int) (p RootHistoryProof, err error) {
+	err = t.ViewHistory(func(h *RootHistory) error {
+		p, err = h.Prove(root)
+		return err
+	})
+	return
+}
+
+// Update calls fn with a writer in a new transaction. If fn returns nil the
+// transaction is committed and the new root is published, otherwise the
+// transaction is discarded. Only one Update runs at a time. The transaction is
+// committed by Update: the writer's Commit returns an error.
+func (t *Tree) Update(fn func(TreeWriter) error) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	tx := t.db.NewTransaction()
+	defer tx.Discard()
+	w := NewTreeWriter(tx, t.levels, t.feLen, t.hash, t.opts...).(*treeWriter)
+	if err := fn(updateWriter{w}); err != nil {
+		return err
+	}
+	root, err := w.Root()
+	if err != nil {
+		return err
+	}
+	size, err := w.Size()
+	if err != nil {
+		return err
+	}
+
+	if err := t.commit(w, root, size); err != nil {
+		return err
+	}
+	w.notify()
+	return nil
+}
+
+func (t *Tree) commit(w *treeWriter, root *big.Int, size uint64) error {
+	t.commitMu.Lock()
+	defer t.commitMu.Unlock()
+	if err := w.commit(); err != nil {
+		return err
+	}
+	t.root = root
+	t.size = size
+	return nil
+}
+
+// updateWriter is the writer passed to the fn of Tree.Update, which commits
+// the transaction itself.
+type updateWriter struct {
+	*treeWriter
+}
+
+func (updateWriter) Commit() error {
+	return errors.New("transaction is committed by Tree.Update")
+}
@@ -1,15 +1,26 @@
 package imt
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"math/bits"
 
 	"github.com/mdehoog/indexed-merkle-tree/db"
+	"github.com/mdehoog/indexed-merkle-tree/metrics"
 )
 
 const nodeKeyPrefix = byte(0)
 const hashKeyPrefix = byte(1)
 const sizeKeyPrefix = byte(2)
+const historyHashKeyPrefix = byte(3)
+const historySizeKeyPrefix = byte(4)
+const historyIndexKeyPrefix = byte(5)
+const stagedIndexKeyPrefix = byte(6)
+const stagedKeyKeyPrefix = byte(7)
 
 var sizeKey = []byte{sizeKeyPrefix}
 
@@ -21,21 +32,53 @@ type TreeReader interface {
 	Get(key *big.Int) (*big.Int, error)
 	ProveInclusion(key *big.Int) (Proof, error)
 	ProveExclusion(key *big.Int) (Proof, error)
+	ProveRangeExclusion(start, end *big.Int) (Proof, error)
+	ProveRange(start, end *big.Int, maxNodes int) (RangeProof, error)
+	Export(w io.Writer) error
+}
+
+// hashGetter reads the hash stored at an index and level, returning
+// db.ErrNotFound if there is none.
+type hashGetter interface {
+	getHash(index, level uint64) (element, error)
 }
 
 type treeReader struct {
-	reader db.Reader
-	levels uint64
-	feLen  uint64
-	hash   HashFn
+	reader      db.Reader
+	levels      uint64
+	feLen       uint64
+	hash        HashFn
+	elementHash ElementHashFn
+	cache       *Cache
+	metrics     metrics.Recorder
+	hashes      hashGetter
+}
+
+func NewTreeReader(reader db.Reader, levels, feLen uint64, hash HashFn, opts ...Option) TreeReader {
+	return newTreeReader(reader, levels, feLen, hash, newOptions(opts))
 }
 
-func NewTreeReader(reader db.Reader, levels, feLen uint64, hash HashFn) TreeReader {
-	return &treeReader{
-		reader: reader,
-		levels: levels,
-		feLen:  feLen,
-		hash:   hash,
+func newTreeReader(reader db.Reader, levels, feLen uint64, hash HashFn, o options) *treeReader {
+	t := &treeReader{
+		reader:      reader,
+		levels:      levels,
+		feLen:       feLen,
+		hash:        countHashes(hash, o.recorder),
+		elementHash: o.elementHash,
+		cache:       o.cache,
+		metrics:     o.recorder,
+	}
+	t.hashes = t
+	return t
+}
+
+func countHashes(hash HashFn, recorder metrics.Recorder) HashFn {
+	if recorder == metrics.Nop {
+		return hash
+	}
+	return func(inputs []*big.Int) (*big.Int, error) {
+		recorder.Add(metrics.HashCalls, 1)
+		return hash(inputs)
 	}
 }
 
@@ -43,36 +86,95 @@ func (t *treeReader) Hash(i []*big.Int) (*big.Int, error) {
 	return t.hash(i)
 }
 
+// hashElements hashes the given elements with the ElementHashFn if there is
+// one, and the HashFn otherwise.
+func (t *treeReader) hashElements(elements ...element) (element, error) {
+	if t.elementHash == nil {
+		return hashElements(t.hash, elements...)
+	}
+	t.metrics.Add(metrics.HashCalls, 1)
+	return hashElementsWith(t.elementHash, elements...)
+}
+
+// newElement converts a key or value, which must fit in feLen bytes.
+func (t *treeReader) newElement(i *big.Int) (element, error) {
+	e, err := newElement(i)
+	if err != nil {
+		return e, err
+	}
+	if !t.fits(e) {
+		return e, fmt.Errorf("%s does not fit in %d bytes", i, t.feLen)
+	}
+	return e, nil
+}
+
+// fits returns whether e fits in feLen bytes.
+func (t *treeReader) fits(e element) bool {
+	return len(e.bytes()) <= int(t.feLen)
+}
+
+// inCapacity returns whether a tree of the given size, with nodes at indices
+// [0, size], fits in the tree. The greatest uint64 is never a valid size, so
+// that size+1 does not overflow in trees of 64 or more levels.
+func (t *treeReader) inCapacity(size uint64) bool {
+	if size == math.MaxUint64 {
+		return false
+	}
+	return t.levels >= 64 || size>>t.levels == 0
+}
+
+// hashNode hashes a node, with the ElementHashFn if there is one.
+func (t *treeReader) hashNode(n *node) (element, error) {
+	return t.hashElements(n.key, n.value, n.nextKey)
+}
+
 func (t *treeReader) Levels() uint64 {
 	return t.levels
 }
 
 func (t *treeReader) Root() (*big.Int, error) {
-	rootNodeBytes, err := t.reader.Get(t.hashKey(0, 0))
+	size, err := t.Size()
+	if err != nil {
+		return nil, err
+	}
+	root, err := t.root(t.hashes, size)
+	if err != nil {
+		return nil, err
+	}
+	return root.BigInt(), nil
+}
+
+func (t *treeReader) root(g hashGetter, size uint64) (element, error) {
+	rootNode, err := g.getHash(0, 0)
 	if errors.Is(err, db.ErrNotFound) {
 		// initial state: hash of empty node
-		initialHash, err := initialStateNode().Hash(t.hash)
+		rootNode, err = t.hashNode(initialStateNode())
 		if err != nil {
-			return nil, err
+			return element{}, err
 		}
-		rootNodeBytes = initialHash.Bytes()
 	} else if err != nil {
-		return nil, err
+		return element{}, err
 	}
 
 	// hash the root node with the size to calculate the final tree root
-	size, err := t.Size()
-	if err != nil {
-		return nil, err
-	}
-	return t.hash([]*big.Int{new(big.Int).SetBytes(rootNodeBytes), new(big.Int).SetUint64(size)})
+	return t.hashElements(rootNode, elementFromUint64(size))
 }
 
 func (t *treeReader) Size() (uint64, error) {
-	s, err := t.reader.Get(sizeKey)
+	return getUint64(t.reader, sizeKey)
+}
+
+// getUint64 reads an integer stored as an element, returning 0 if there is
+// none.
+func getUint64(reader db.Reader, key []byte) (uint64, error) {
+	s, err := reader.Get(key)
 	if err == nil {
-		b := new(big.Int).SetBytes(s).Uint64()
-		return b, nil
+		if len(s) > 8 {
+			return 0, errors.New("invalid size")
+		}
+		var b [8]byte
+		copy(b[8-len(s):], s)
+		return binary.BigEndian.Uint64(b[:]), nil
 	} else if errors.Is(err, db.ErrNotFound) {
 		return 0, nil
 	} else {
@@ -81,7 +183,12 @@ func (t *treeReader) Size() (uint64, error) {
 }
 
 func (t *treeReader) Get(key *big.Int) (*big.Int, error) {
-	n, err := t.node(key)
+	defer metrics.Trace(t.metrics, metrics.OpGet)()
+	k, err := t.newElement(key)
+	if err != nil {
+		return nil, err
+	}
+	n, err := t.node(k)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +196,12 @@ func (t *treeReader) Get(key *big.Int) (*big.Int, error) {
 }
 
 func (t *treeReader) ProveInclusion(key *big.Int) (Proof, error) {
-	n, err := t.node(key)
+	defer metrics.Trace(t.metrics, metrics.OpProveInclusion)()
+	k, err := t.newElement(key)
+	if err != nil {
+		return nil, err
+	}
+	n, err := t.node(k)
 	if err != nil {
 		return nil, err
 	}
@@ -97,23 +209,52 @@ func (t *treeReader) ProveInclusion(key *big.Int) (Proof, error) {
 }
 
 func (t *treeReader) ProveExclusion(key *big.Int) (Proof, error) {
-	n, err := t.lowNullifierNode(key)
+	defer metrics.Trace(t.metrics, metrics.OpProveExclusion)()
+	k, err := t.newElement(key)
+	if err != nil {
+		return nil, err
+	}
+	n, err := t.lowNullifierNode(k)
 	if err != nil {
 		return nil, err
 	}
 	return t.proveNode(n)
 }
 
-func (t *treeReader) proveNode(n Node) (Proof, error) {
-	root, err := t.Root()
+// ProveRangeExclusion proves that no key in [start, end] exists, by proving the
+// inclusion of the low node of start, whose next key is greater than end.
+func (t *treeReader) ProveRangeExclusion(start, end *big.Int) (Proof, error) {
+	defer metrics.Trace(t.metrics, metrics.OpProveRangeExclusion)()
+	if start.Sign() <= 0 || start.Cmp(end) > 0 {
+		return nil, errors.New("invalid range")
+	}
+	s, err := t.newElement(start)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := t.newElement(end); err != nil {
+		return nil, err
+	}
+	n, err := t.lowNullifierNode(s)
+	if err != nil {
+		return nil, err
+	}
+	if !n.nextKey.isZero() && n.NextKey().Cmp(end) <= 0 {
+		return nil, errors.New("range is not empty")
+	}
+	return t.proveNode(n)
+}
+
+func (t *treeReader) proveNode(n *node) (Proof, error) {
 	size, err := t.Size()
 	if err != nil {
 		return nil, err
 	}
-	siblings, err := t.proveSiblings(n)
+	root, err := t.root(t.hashes, size)
+	if err != nil {
+		return nil, err
+	}
+	siblings, err := t.siblings(t.hashes, n.Index())
 	if err != nil {
 		return nil, err
 	}
@@ -125,22 +266,44 @@ func (t *treeReader) proveNode(n Node) (Proof, error) {
 	}, nil
 }
 
-func (t *treeReader) proveSiblings(n Node) ([]*big.Int, error) {
-	siblings := make([]*big.Int, t.levels)
-	index := n.Index()
+func (t *treeReader) siblings(g hashGetter, index uint64) ([]element, error) {
+	siblings := make([]element, t.levels)
 	for level := t.levels; level > 0; index /= 2 {
 		level--
 		siblingIndex := index + 1 - (index%2)*2
-		siblingHashBytes, err := t.reader.Get(t.hashKey(siblingIndex, level+1))
+		siblingHash, err := g.getHash(siblingIndex, level+1)
 		if err != nil && !errors.Is(err, db.ErrNotFound) {
 			return nil, err
 		}
-		siblings[level] = new(big.Int).SetBytes(siblingHashBytes)
+		siblings[level] = siblingHash
 	}
 	return siblings, nil
 }
 
-func (t *treeReader) node(key *big.Int) (*node, error) {
+func (t *treeReader) getHash(index, level uint64) (element, error) {
+	if h, ok, found := t.cache.get(index, level); found {
+		if !ok {
+			return element{}, db.ErrNotFound
+		}
+		return h, nil
+	}
+	h, err := t.readHash(index, level)
+	if err == nil || errors.Is(err, db.ErrNotFound) {
+		t.cache.add(index, level, h, err == nil)
+	}
+	return h, err
+}
+
+// readHash reads a hash from the database, bypassing the cache.
+func (t *treeReader) readHash(index, level uint64) (element, error) {
+	b, err := t.reader.Get(t.hashKey(index, level))
+	if err != nil {
+		return element{}, err
+	}
+	return elementFromBytes(b)
+}
+
+func (t *treeReader) node(key element) (*node, error) {
 	b, err := t.reader.Get(t.nodeKey(key))
 	if err != nil {
 		return nil, err
@@ -148,7 +311,7 @@ func (t *treeReader) node(key *big.Int) (*node, error) {
 	return fromBytes(key, b)
 }
 
-func (t *treeReader) lowNullifierNode(key *big.Int) (*node, error) {
+func (t *treeReader) lowNullifierNode(key element) (*node, error) {
 	k, b, err := t.reader.GetLT(t.nodeKey(key))
 	if err != nil {
 		return nil, err
@@ -156,24 +319,58 @@ func (t *treeReader) lowNullifierNode(key *big.Int) (*node, error) {
 	if k == nil {
 		return initialStateNode(), nil
 	}
-	return fromBytes(nodeKeyBytesToKey(k), b)
+	nk, err := nodeKeyBytesToKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return fromBytes(nk, b)
 }
 
-func (t *treeReader) nodeKey(key *big.Int) []byte {
-	b := key.Bytes()
-	prefix := make([]byte, 1+int(t.feLen)-len(b))
-	prefix[0] = nodeKeyPrefix
-	return append(prefix, b...)
+// nodeKey returns the key of the node with the given key, padded to feLen
+// bytes. Keys longer than feLen are never stored, and are left unpadded.
+func (t *treeReader) nodeKey(key element) []byte {
+	b := key.bytes()
+	k := make([]byte, 1+max(int(t.feLen), len(b)))
+	k[0] = nodeKeyPrefix
+	copy(k[len(k)-len(b):], b)
+	return k
 }
 
+// hashKey returns the key of the hash at the given index and level, which is
+// stored at position 2^(levels+1) - 2^(level+1) + index.
 func (t *treeReader) hashKey(index, level uint64) []byte {
-	one := big.NewInt(1)
-	position := new(big.Int).Lsh(one, uint(t.levels+1))
-	position.Sub(position, new(big.Int).Lsh(one, uint(level+1)))
-	position.Add(position, new(big.Int).SetUint64(index))
-	return append([]byte{hashKeyPrefix}, position.Bytes()...)
+	return positionKey(hashKeyPrefix, t.levels, index, level)
+}
+
+func positionKey(prefix byte, levels, index, level uint64) []byte {
+	hi, lo := pow2(levels + 1)
+	h, l := pow2(level + 1)
+	lo, borrow := bits.Sub64(lo, l, 0)
+	hi, _ = bits.Sub64(hi, h, borrow)
+	lo, carry := bits.Add64(lo, index, 0)
+	hi += carry
+
+	var position [16]byte
+	binary.BigEndian.PutUint64(position[:8], hi)
+	binary.BigEndian.PutUint64(position[8:], lo)
+	i := 0
+	for i < len(position) && position[i] == 0 {
+		i++
+	}
+	k := make([]byte, 1+len(position)-i)
+	k[0] = prefix
+	copy(k[1:], position[i:])
+	return k
+}
+
+// pow2 returns 2^n as a 128-bit integer.
+func pow2(n uint64) (hi, lo uint64) {
+	if n < 64 {
+		return 0, 1 << n
+	}
+	return 1 << (n - 64), 0
 }
 
-func nodeKeyBytesToKey(b []byte) *big.Int {
-	return new(big.Int).SetBytes(b[1:])
+func nodeKeyBytesToKey(b []byte) (element, error) {
+	return elementFromBytes(b[1:])
 }
@@ -0,0 +1,152 @@
+package imt
+
+import (
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/mdehoog/indexed-merkle-tree/db"
+	"github.com/mdehoog/poseidon/poseidon"
+)
+
+var testHash HashFn = poseidon.Hash[*fr.Element]
+
+func testDB(t testing.TB) db.Database {
+	_, d := testPebble(t)
+	return d
+}
+
+func testPebble(t testing.TB) (*pebble.DB, db.Database) {
+	p, err := pebble.Open(t.TempDir(), &pebble.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := db.NewPebble(p)
+	t.Cleanup(func() { _ = d.Close() })
+	return p, d
+}
+
+// testKeys returns n distinct random non-zero keys.
+func testKeys(n int, seed int64) []*big.Int {
+	r := rand.New(rand.NewSource(seed))
+	max := new(big.Int).Lsh(big.NewInt(1), 200)
+	seen := make(map[string]bool)
+	var keys []*big.Int
+	for len(keys) < n {
+		k := new(big.Int).Rand(r, max)
+		if k.Sign() == 0 || seen[k.String()] {
+			continue
+		}
+		seen[k.String()] = true
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestTreeConcurrentReaders(t *testing.T) {
+	tree, err := NewTree(testDB(t), 16, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				err := tree.View(func(r TreeReader) error {
+					p, err := r.ProveExclusion(big.NewInt(7))
+					if err != nil {
+						return err
+					}
+					ok, err := p.Valid(r)
+					if err == nil && !ok {
+						t.Error("invalid exclusion proof")
+					}
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	keys := testKeys(50, 1)
+	for _, k := range keys {
+		err := tree.Update(func(w TreeWriter) error {
+			_, err := w.Insert(k, big.NewInt(1))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if tree.Size() != uint64(len(keys)) {
+		t.Fatalf("size %d, want %d", tree.Size(), len(keys))
+	}
+	root, err := tree.reader().Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Cmp(tree.Root()) != 0 {
+		t.Fatal("published root differs from the stored root")
+	}
+}
+
+func TestTreeUpdateCommit(t *testing.T) {
+	tree, err := NewTree(testDB(t), 16, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Update(func(w TreeWriter) error {
+		if _, err := w.Insert(big.NewInt(1), big.NewInt(1)); err != nil {
+			return err
+		}
+		return w.Commit()
+	})
+	if err == nil {
+		t.Fatal("expected Commit to fail inside Update")
+	}
+	if tree.Size() != 0 {
+		t.Fatalf("size %d after a failed update", tree.Size())
+	}
+	if _, err := tree.Get(big.NewInt(1)); err == nil {
+		t.Fatal("failed update was committed")
+	}
+}
+
+func TestTreeUpdateError(t *testing.T) {
+	tree, err := NewTree(testDB(t), 16, fr.Bytes, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+	err = tree.Update(func(w TreeWriter) error {
+		if _, err := w.Insert(big.NewInt(1), big.NewInt(1)); err != nil {
+			return err
+		}
+		_, err := w.Insert(big.NewInt(1), big.NewInt(2))
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected duplicate insert to fail")
+	}
+	if tree.Root().Cmp(root) != 0 || tree.Size() != 0 {
+		t.Fatal("failed update changed the tree")
+	}
+}
@@ -3,8 +3,11 @@ package imt
 import (
 	"errors"
 	"math/big"
+	"sort"
+	"sync"
 
 	"github.com/mdehoog/indexed-merkle-tree/db"
+	"github.com/mdehoog/indexed-merkle-tree/metrics"
 )
 
 type TreeWriter interface {
@@ -12,27 +15,134 @@ type TreeWriter interface {
 	Set(key, value *big.Int) (MutateProof, error)
 	Insert(key, value *big.Int) (MutateProof, error)
 	Update(key, value *big.Int) (MutateProof, error)
+	Flush() ([]MutateProof, error)
+	Commit() error
 }
 
 type treeWriter struct {
 	*treeReader
-	tx db.Transaction
+	tx   db.Transaction
+	opts options
+
+	// pending mutations and their proofs when hashing is deferred
+	pending []*mutation
+	proofs  []MutateProof
+
+	// hashes written in this transaction, published to the cache on commit
+	written map[hashPosition]element
+
+	// mutations made in this transaction, published to observers on commit
+	mutations     []Mutation
+	committedRoot *big.Int
+	committedSize uint64
+
+	// hooked is set if the transaction calls prepare and finish however it is
+	// committed. committing is set while commit runs, as its caller notifies
+	// the observers.
+	hooked     bool
+	committing bool
 }
 
-func NewTreeWriter(tx db.Transaction, levels, feLen uint64, hash HashFn) TreeWriter {
-	return &treeWriter{
-		tx: tx,
-		treeReader: &treeReader{
-			reader: tx,
-			levels: levels,
-			feLen:  feLen,
-			hash:   hash,
-		},
+// hashStore reads and writes the hash stored at an index and level.
+type hashStore interface {
+	hashGetter
+	setHash(index, level uint64, h element) error
+}
+
+type mutation struct {
+	node    *node
+	lowNode *node // low node after an insert, node before an update
+	oldSize uint64
+	update  bool
+}
+
+// NewTreeWriter returns a writer that mutates the tree in tx. If tx implements
+// db.CommitHooks, committing tx directly is equivalent to calling Commit.
+// Otherwise the writer must be committed with Commit, as committing tx
+// directly skips any deferred hashes, the root history, the cache and the
+// observers.
+func NewTreeWriter(tx db.Transaction, levels, feLen uint64, hash HashFn, opts ...Option) TreeWriter {
+	return newTreeWriter(tx, levels, feLen, hash, newOptions(opts))
+}
+
+func newTreeWriter(tx db.Transaction, levels, feLen uint64, hash HashFn, o options) *treeWriter {
+	t := &treeWriter{
+		tx:         tx,
+		treeReader: newTreeReader(tx, levels, feLen, hash, o),
+		opts:       o,
+		written:    make(map[hashPosition]element),
+	}
+	t.hashes = t
+	if h, ok := tx.(db.CommitHooks); ok {
+		h.BeforeCommit(t.prepare)
+		h.AfterCommit(t.finish)
+		t.hooked = true
+	}
+	return t
+}
+
+// getHash reads through the cache without populating it, as the transaction
+// is not committed.
+func (t *treeWriter) getHash(index, level uint64) (element, error) {
+	if h, ok := t.written[hashPosition{index: index, level: level}]; ok {
+		return h, nil
+	}
+	if h, ok, found := t.cache.get(index, level); found {
+		if !ok {
+			return element{}, db.ErrNotFound
+		}
+		return h, nil
+	}
+	return t.readHash(index, level)
+}
+
+func (t *treeWriter) setHash(index, level uint64, h element) error {
+	err := t.tx.Set(t.hashKey(index, level), h.bytes())
+	if err != nil || t.cache == nil {
+		return err
 	}
+	t.written[hashPosition{index: index, level: level}] = h
+	return nil
 }
 
 func (t *treeWriter) setSize(s uint64) error {
-	return t.tx.Set(sizeKey, new(big.Int).SetUint64(s).Bytes())
+	e := elementFromUint64(s)
+	return t.tx.Set(sizeKey, e.bytes())
+}
+
+func (t *treeWriter) Root() (*big.Int, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader.Root()
+}
+
+func (t *treeWriter) ProveInclusion(key *big.Int) (Proof, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader.ProveInclusion(key)
+}
+
+func (t *treeWriter) ProveExclusion(key *big.Int) (Proof, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader.ProveExclusion(key)
+}
+
+func (t *treeWriter) ProveRangeExclusion(start, end *big.Int) (Proof, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader.ProveRangeExclusion(start, end)
+}
+
+func (t *treeWriter) ProveRange(start, end *big.Int, maxNodes int) (RangeProof, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	return t.treeReader.ProveRange(start, end, maxNodes)
 }
 
 func (t *treeWriter) Set(key, value *big.Int) (MutateProof, error) {
@@ -48,156 +158,212 @@ func (t *treeWriter) Set(key, value *big.Int) (MutateProof, error) {
 }
 
 func (t *treeWriter) Insert(key, value *big.Int) (MutateProof, error) {
-	_, err := t.tx.Get(t.nodeKey(key))
+	defer metrics.Trace(t.metrics, metrics.OpInsert)()
+	k, err := t.newElement(key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := t.newElement(value)
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.tx.Get(t.nodeKey(k))
 	if err == nil {
 		return nil, errors.New("key already exists")
 	} else if !errors.Is(err, db.ErrNotFound) {
 		return nil, err
 	}
 
-	lowNode, err := t.lowNullifierNode(key)
+	lowNode, err := t.lowNullifierNode(k)
 	if err != nil {
 		return nil, err
 	}
 
-	oldSiblings, err := t.proveSiblings(lowNode)
+	size, err := t.Size()
 	if err != nil {
 		return nil, err
 	}
+	if !t.inCapacity(size + 1) {
+		return nil, errors.New("tree is over capacity")
+	}
 
-	oldRoot, err := t.Root()
+	err = t.setSize(size + 1)
 	if err != nil {
 		return nil, err
 	}
 
-	size, err := t.Size()
+	m := &mutation{
+		node: &node{
+			key:     k,
+			index:   size + 1,
+			value:   v,
+			nextKey: lowNode.nextKey,
+		},
+		lowNode: &node{
+			key:     lowNode.key,
+			index:   lowNode.index,
+			value:   lowNode.value,
+			nextKey: k,
+		},
+		oldSize: size,
+	}
+	err = t.setNodes(m.node, m.lowNode)
 	if err != nil {
 		return nil, err
 	}
-	size++
+	return t.mutate(m)
+}
 
-	err = t.setSize(size)
+func (t *treeWriter) Update(key, value *big.Int) (MutateProof, error) {
+	defer metrics.Trace(t.metrics, metrics.OpUpdate)()
+	k, err := t.newElement(key)
 	if err != nil {
 		return nil, err
 	}
-
-	newNode := &node{
-		key:     key,
-		index:   size,
-		value:   value,
-		nextKey: lowNode.NextKey(),
-	}
-	_, err = t.setNode(newNode)
+	v, err := t.newElement(value)
 	if err != nil {
 		return nil, err
 	}
-
-	lowNode = &node{
-		key:     lowNode.Key(),
-		index:   lowNode.Index(),
-		value:   lowNode.Value(),
-		nextKey: key,
+	n, err := t.node(k)
+	if err != nil {
+		return nil, err
 	}
-	lowSiblings, err := t.setNode(lowNode)
+	size, err := t.Size()
 	if err != nil {
 		return nil, err
 	}
-
-	newRoot, err := t.Root()
+	m := &mutation{
+		node: &node{
+			key:     k,
+			index:   n.index,
+			value:   v,
+			nextKey: n.nextKey,
+		},
+		lowNode: n,
+		oldSize: size,
+		update:  true,
+	}
+	err = t.setNodes(m.node)
 	if err != nil {
 		return nil, err
 	}
-	siblings, err := t.proveSiblings(newNode)
+	return t.mutate(m)
+}
+
+func (t *treeWriter) mutate(m *mutation) (MutateProof, error) {
+	if t.opts.deferred {
+		t.pending = append(t.pending, m)
+		return nil, nil
+	}
+	p, err := t.apply(t, m)
 	if err != nil {
 		return nil, err
 	}
+	t.observe(m, p)
+	return p, nil
+}
 
-	return &mutateProof{
-		oldRoot:     oldRoot,
-		oldSize:     size - 1,
-		oldSiblings: oldSiblings,
-		newRoot:     newRoot,
-		node:        newNode,
-		siblings:    siblings,
-		lowNode:     lowNode,
-		lowSiblings: lowSiblings,
-		update:      false,
-	}, nil
+func (t *treeWriter) observe(m *mutation, p MutateProof) {
+	if len(t.opts.observers) > 0 {
+		t.mutations = append(t.mutations, newMutation(m, p))
+	}
 }
 
-func (t *treeWriter) Update(key, value *big.Int) (MutateProof, error) {
-	oldRoot, err := t.Root()
+// apply hashes the nodes of a mutation into s, returning the proof of the
+// mutation.
+func (t *treeWriter) apply(s hashStore, m *mutation) (MutateProof, error) {
+	oldRoot, err := t.root(s, m.oldSize)
 	if err != nil {
 		return nil, err
 	}
-	n, err := t.node(key)
+
+	if m.update {
+		siblings, err := t.setLeaf(s, m.node)
+		if err != nil {
+			return nil, err
+		}
+		newRoot, err := t.root(s, m.oldSize)
+		if err != nil {
+			return nil, err
+		}
+		return &mutateProof{
+			oldRoot:     oldRoot,
+			oldSize:     m.oldSize,
+			oldSiblings: siblings,
+			newRoot:     newRoot,
+			node:        m.node,
+			siblings:    siblings,
+			lowNode:     m.lowNode,
+			lowSiblings: siblings,
+			update:      true,
+		}, nil
+	}
+
+	oldSiblings, err := t.siblings(s, m.lowNode.index)
 	if err != nil {
 		return nil, err
 	}
-	oldValue := n.Value()
-	n = &node{
-		key:     key,
-		index:   n.Index(),
-		value:   value,
-		nextKey: n.NextKey(),
+	_, err = t.setLeaf(s, m.node)
+	if err != nil {
+		return nil, err
 	}
-	siblings, err := t.setNode(n)
+	lowSiblings, err := t.setLeaf(s, m.lowNode)
 	if err != nil {
 		return nil, err
 	}
-	newRoot, err := t.Root()
+	newRoot, err := t.root(s, m.oldSize+1)
 	if err != nil {
 		return nil, err
 	}
-	size, err := t.Size()
+	siblings, err := t.siblings(s, m.node.index)
 	if err != nil {
 		return nil, err
 	}
+
 	return &mutateProof{
 		oldRoot:     oldRoot,
-		oldSize:     size,
-		oldSiblings: siblings,
+		oldSize:     m.oldSize,
+		oldSiblings: oldSiblings,
 		newRoot:     newRoot,
-		node:        n,
+		node:        m.node,
 		siblings:    siblings,
-		lowNode: &node{
-			key:     n.Key(),
-			index:   n.Index(),
-			value:   oldValue,
-			nextKey: n.NextKey(),
-		},
-		lowSiblings: siblings,
-		update:      true,
+		lowNode:     m.lowNode,
+		lowSiblings: lowSiblings,
+		update:      false,
 	}, nil
 }
 
-func (t *treeWriter) setNode(n *node) ([]*big.Int, error) {
-	err := t.tx.Set(t.nodeKey(n.Key()), n.bytes())
-	if err != nil {
-		return nil, err
+func (t *treeWriter) setNodes(nodes ...*node) error {
+	for _, n := range nodes {
+		err := t.tx.Set(t.nodeKey(n.key), n.bytes())
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	h, err := n.Hash(t.hash)
+func (t *treeWriter) setLeaf(s hashStore, n *node) ([]element, error) {
+	h, err := t.hashNode(n)
 	if err != nil {
 		return nil, err
 	}
-	err = t.tx.Set(t.hashKey(n.Index(), t.levels), h.Bytes())
+	err = s.setHash(n.index, t.levels, h)
 	if err != nil {
 		return nil, err
 	}
 
-	index := n.Index()
-	siblings := make([]*big.Int, t.levels)
+	index := n.index
+	siblings := make([]element, t.levels)
 	for level := t.levels; level > 0; {
 		level--
 		siblingIndex := index + 1 - (index%2)*2
-		siblingHashBytes, err := t.tx.Get(t.hashKey(siblingIndex, level+1))
-		siblings[level] = new(big.Int).SetBytes(siblingHashBytes)
+		siblings[level], err = s.getHash(siblingIndex, level+1)
 		if err == nil {
 			if index%2 == 0 {
-				h, err = t.hash([]*big.Int{h, siblings[level]})
+				h, err = t.hashElements(h, siblings[level])
 			} else {
-				h, err = t.hash([]*big.Int{siblings[level], h})
+				h, err = t.hashElements(siblings[level], h)
 			}
 			if err != nil {
 				return nil, err
@@ -207,7 +373,7 @@ func (t *treeWriter) setNode(n *node) ([]*big.Int, error) {
 		}
 
 		index /= 2
-		err = t.tx.Set(t.hashKey(index, level), h.Bytes())
+		err = s.setHash(index, level, h)
 		if err != nil {
 			return nil, err
 		}
@@ -218,3 +384,316 @@ func (t *treeWriter) setNode(n *node) ([]*big.Int, error) {
 
 	return siblings, nil
 }
+
+func (t *treeWriter) Flush() ([]MutateProof, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	proofs := t.proofs
+	t.proofs = nil
+	return proofs, nil
+}
+
+func (t *treeWriter) Commit() error {
+	if err := t.commit(); err != nil {
+		return err
+	}
+	t.notify()
+	return nil
+}
+
+// commit commits the transaction. Observers are notified separately by notify.
+func (t *treeWriter) commit() error {
+	defer metrics.Trace(t.metrics, metrics.OpCommit)()
+	if t.hooked {
+		t.committing = true
+		defer func() { t.committing = false }()
+		return t.tx.Commit()
+	}
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	t.finish()
+	return nil
+}
+
+// prepare flushes the pending mutations and appends the root to the history
+// before the transaction is committed.
+func (t *treeWriter) prepare() error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	var err error
+	if len(t.opts.observers) > 0 || t.opts.historyLevels > 0 {
+		if t.committedRoot, err = t.treeReader.Root(); err != nil {
+			return err
+		}
+	}
+	if t.opts.historyLevels > 0 {
+		h := NewRootHistory(t.tx, t.opts.historyLevels, t.hash)
+		root, err := newElement(t.committedRoot)
+		if err != nil {
+			return err
+		}
+		if err := h.append(t.tx, root); err != nil {
+			return err
+		}
+	}
+	t.committedSize, err = t.Size()
+	return err
+}
+
+// finish publishes the written hashes to the cache once the transaction is
+// committed, and notifies the observers if it was committed directly.
+func (t *treeWriter) finish() {
+	t.metrics.SetSize(t.committedSize)
+	t.publish()
+	if !t.committing {
+		t.notify()
+	}
+}
+
+// publish adds the hashes written in the committed transaction to the cache.
+func (t *treeWriter) publish() {
+	for p, h := range t.written {
+		t.cache.add(p.index, p.level, h, true)
+	}
+	t.written = make(map[hashPosition]element)
+}
+
+func (t *treeWriter) notify() {
+	if len(t.opts.observers) == 0 {
+		return
+	}
+	for _, o := range t.opts.observers {
+		for _, m := range t.mutations {
+			o.OnMutation(m)
+		}
+		o.OnCommit(t.committedRoot, t.committedSize)
+	}
+	t.mutations = nil
+}
+
+func (t *treeWriter) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	defer metrics.Trace(t.metrics, metrics.OpFlush)()
+	var err error
+	if t.opts.deferredProofs || len(t.opts.observers) > 0 {
+		err = t.replay()
+	} else {
+		err = t.rehash()
+	}
+	if err != nil {
+		return err
+	}
+	t.pending = nil
+	return nil
+}
+
+// replay applies the pending mutations in order to an in-memory overlay,
+// collecting their proofs for Flush and the observers, and then writes each
+// resulting hash once.
+func (t *treeWriter) replay() error {
+	o := newOverlay(t)
+	var proofs []MutateProof
+	for _, m := range t.pending {
+		p, err := t.apply(o, m)
+		if err != nil {
+			return err
+		}
+		proofs = append(proofs, p)
+	}
+	if err := o.write(); err != nil {
+		return err
+	}
+	for i, m := range t.pending {
+		t.observe(m, proofs[i])
+	}
+	if t.opts.deferredProofs {
+		t.proofs = append(t.proofs, proofs...)
+	}
+	return nil
+}
+
+// rehash recomputes the hashes of the leaves touched by the pending mutations,
+// and then each of their ancestors once, level by level.
+func (t *treeWriter) rehash() error {
+	leaves := make(map[uint64]*node)
+	for _, m := range t.pending {
+		leaves[m.node.index] = m.node
+		if !m.update {
+			leaves[m.lowNode.index] = m.lowNode
+		}
+	}
+	indices := make([]uint64, 0, len(leaves))
+	for index := range leaves {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	hashes := make([]element, len(indices))
+	err := t.parallel(len(indices), func(i int) (err error) {
+		hashes[i], err = t.hashNode(leaves[indices[i]])
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	for level := t.levels; ; level-- {
+		for i, index := range indices {
+			err := t.setHash(index, level, hashes[i])
+			if err != nil {
+				return err
+			}
+		}
+		if level == 0 {
+			if indices[0] != 0 || len(indices) != 1 {
+				return errors.New("tree is over capacity")
+			}
+			return nil
+		}
+		var err error
+		indices, hashes, err = t.rehashLevel(level, indices, hashes)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// children holds the hashes of the children of a node, either of which may be
+// missing.
+type children struct {
+	left, right       element
+	hasLeft, hasRight bool
+}
+
+// rehashLevel computes the parents of the given sorted indices at level.
+func (t *treeWriter) rehashLevel(level uint64, indices []uint64, hashes []element) ([]uint64, []element, error) {
+	var parents []uint64
+	var pairs []children
+	for i := 0; i < len(indices); i++ {
+		index, h := indices[i], hashes[i]
+		siblingIndex := index + 1 - (index%2)*2
+		var sibling element
+		hasSibling := true
+		if i+1 < len(indices) && indices[i+1] == siblingIndex {
+			sibling = hashes[i+1]
+			i++
+		} else {
+			var err error
+			sibling, err = t.getHash(siblingIndex, level)
+			if errors.Is(err, db.ErrNotFound) {
+				hasSibling = false
+			} else if err != nil {
+				return nil, nil, err
+			}
+		}
+		parents = append(parents, index/2)
+		if index%2 == 0 {
+			pairs = append(pairs, children{left: h, right: sibling, hasLeft: true, hasRight: hasSibling})
+		} else {
+			pairs = append(pairs, children{left: sibling, right: h, hasLeft: hasSibling, hasRight: true})
+		}
+	}
+
+	parentHashes := make([]element, len(parents))
+	err := t.parallel(len(parents), func(i int) (err error) {
+		switch c := pairs[i]; {
+		case !c.hasLeft:
+			parentHashes[i] = c.right
+		case !c.hasRight:
+			parentHashes[i] = c.left
+		default:
+			parentHashes[i], err = t.hashElements(c.left, c.right)
+		}
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return parents, parentHashes, nil
+}
+
+// parallel calls fn for each i in [0, n), split across the configured number
+// of workers.
+func (t *treeWriter) parallel(n int, fn func(i int) error) error {
+	workers := t.opts.workers
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * n / workers; i < (w+1)*n/workers; i++ {
+				if err := fn(i); err != nil {
+					errs[w] = err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// overlay buffers hashes in memory on top of another store.
+type overlay struct {
+	store  hashStore
+	hashes map[hashPosition]element
+}
+
+func newOverlay(store hashStore) *overlay {
+	return &overlay{
+		store:  store,
+		hashes: make(map[hashPosition]element),
+	}
+}
+
+func (o *overlay) getHash(index, level uint64) (element, error) {
+	if h, ok := o.hashes[hashPosition{index: index, level: level}]; ok {
+		return h, nil
+	}
+	return o.store.getHash(index, level)
+}
+
+func (o *overlay) setHash(index, level uint64, h element) error {
+	o.hashes[hashPosition{index: index, level: level}] = h
+	return nil
+}
+
+func (o *overlay) write() error {
+	positions := make([]hashPosition, 0, len(o.hashes))
+	for p := range o.hashes {
+		positions = append(positions, p)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].level != positions[j].level {
+			return positions[i].level < positions[j].level
+		}
+		return positions[i].index < positions[j].index
+	})
+	for _, p := range positions {
+		if err := o.store.setHash(p.index, p.level, o.hashes[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
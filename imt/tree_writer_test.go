@@ -0,0 +1,156 @@
+package imt
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+type testOp struct {
+	key, value *big.Int
+}
+
+// testOps returns n inserts interleaved with updates of earlier keys.
+func testOps(n int) []testOp {
+	keys := testKeys(n, 3)
+	var ops []testOp
+	for i, k := range keys {
+		ops = append(ops, testOp{k, big.NewInt(int64(i))})
+		if i%3 == 0 {
+			ops = append(ops, testOp{keys[i/2], big.NewInt(int64(1000 + i))})
+		}
+	}
+	return ops
+}
+
+// testBuild applies ops in one transaction, returning the contents of the
+// database, the proofs returned by the writer, and the root.
+func testBuild(t testing.TB, ops []testOp, levels uint64, opts ...Option) (string, []MutateProof, *big.Int) {
+	p, d := testPebble(t)
+	w := NewTreeWriter(d.NewTransaction(), levels, fr.Bytes, testHash, opts...)
+	var proofs []MutateProof
+	for i, op := range ops {
+		mp, err := w.Set(op.key, op.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mp != nil {
+			proofs = append(proofs, mp)
+		}
+		if i == len(ops)/2 {
+			// flush halfway through
+			if _, err := w.Root(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fp, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs = append(proofs, fp...)
+	root, err := w.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return testDump(t, p), proofs, root
+}
+
+// testDump returns every key and value in the database.
+func testDump(t testing.TB, p *pebble.DB) string {
+	it, err := p.NewIter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var b bytes.Buffer
+	for it.First(); it.Valid(); it.Next() {
+		fmt.Fprintf(&b, "%x=%x\n", it.Key(), it.Value())
+	}
+	return b.String()
+}
+
+func TestDeferredHashing(t *testing.T) {
+	ops := testOps(60)
+	a, ap, ar := testBuild(t, ops, 10)
+	b, bp, br := testBuild(t, ops, 10, WithDeferredHashing(true))
+	c, cp, cr := testBuild(t, ops, 10, WithDeferredHashing(false))
+	if a != b || a != c {
+		t.Fatal("deferred hashing wrote different data")
+	}
+	if ar.Cmp(br) != 0 || ar.Cmp(cr) != 0 {
+		t.Fatal("deferred hashing computed a different root")
+	}
+	if len(ap) != len(ops) || len(bp) != len(ops) || len(cp) != 0 {
+		t.Fatalf("got %d, %d and %d proofs for %d mutations", len(ap), len(bp), len(cp), len(ops))
+	}
+	for i := range ap {
+		if fmt.Sprint(ap[i]) != fmt.Sprint(bp[i]) {
+			t.Fatalf("proof %d: got %v, want %v", i, bp[i], ap[i])
+		}
+	}
+}
+
+func TestParallelHashing(t *testing.T) {
+	ops := testOps(500)
+	a, _, ar := testBuild(t, ops, 12)
+	for _, workers := range []int{2, 8} {
+		b, _, br := testBuild(t, ops, 12, WithDeferredHashing(false), WithWorkers(workers))
+		if a != b || ar.Cmp(br) != 0 {
+			t.Fatalf("%d workers: parallel hashing differs from sequential hashing", workers)
+		}
+	}
+}
+
+func TestDeferredHashingDirectCommit(t *testing.T) {
+	ops := testOps(60)
+	a, _, ar := testBuild(t, ops, 10)
+	for _, proofs := range []bool{false, true} {
+		p, d := testPebble(t)
+		tx := d.NewTransaction()
+		w := NewTreeWriter(tx, 10, fr.Bytes, testHash, WithDeferredHashing(proofs))
+		for _, op := range ops {
+			if _, err := w.Set(op.key, op.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// committing the transaction directly flushes the pending mutations
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		root, err := NewTreeReader(d, 10, fr.Bytes, testHash).Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testDump(t, p) != a || root.Cmp(ar) != 0 {
+			t.Fatalf("proofs %v: direct commit wrote different data", proofs)
+		}
+	}
+}
+
+func TestElementLength(t *testing.T) {
+	w := NewTreeWriter(testDB(t).NewTransaction(), 10, 4, testHash)
+	long := new(big.Int).Lsh(big.NewInt(1), 40)
+	if _, err := w.Insert(big.NewInt(1), big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Insert(long, big.NewInt(1)); err == nil {
+		t.Fatal("expected long key to fail")
+	}
+	if _, err := w.Update(big.NewInt(1), long); err == nil {
+		t.Fatal("expected long value to fail")
+	}
+	if _, err := w.Get(long); err == nil {
+		t.Fatal("expected long key to fail")
+	}
+	if _, err := w.ProveExclusion(long); err == nil {
+		t.Fatal("expected long key to fail")
+	}
+}
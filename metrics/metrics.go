@@ -0,0 +1,86 @@
+package metrics
+
+import "time"
+
+type Counter int
+
+const (
+	HashCalls Counter = iota
+	DBGets
+	DBSets
+	DBSeeks
+	DBDeletes
+	numCounters
+)
+
+func (c Counter) String() string {
+	switch c {
+	case HashCalls:
+		return "hash_calls"
+	case DBGets:
+		return "db_gets"
+	case DBSets:
+		return "db_sets"
+	case DBSeeks:
+		return "db_seeks"
+	case DBDeletes:
+		return "db_deletes"
+	}
+	return "unknown"
+}
+
+// Operations timed by the tree.
+const (
+	OpGet                 = "get"
+	OpProveInclusion      = "prove_inclusion"
+	OpProveExclusion      = "prove_exclusion"
+	OpProveRangeExclusion = "prove_range_exclusion"
+	OpProveRange          = "prove_range"
+	OpInsert              = "insert"
+	OpUpdate              = "update"
+	OpFlush               = "flush"
+	OpCommit              = "commit"
+)
+
+// Recorder receives instrumentation from trees and databases. Implementations
+// must be safe for concurrent use.
+type Recorder interface {
+	// Add increments the counter by n.
+	Add(c Counter, n uint64)
+
+	// Observe records the duration of an operation.
+	Observe(op string, d time.Duration)
+
+	// SetSize records the size of the tree after a commit.
+	SetSize(size uint64)
+
+	// StartSpan is called when an operation starts, and returns the function
+	// to call when it ends, so that tracers can follow operations. Operations
+	// may be nested, such as a flush within a commit.
+	StartSpan(op string) (end func())
+}
+
+// Nop is a Recorder that discards everything.
+var Nop Recorder = nop{}
+
+type nop struct{}
+
+func (nop) Add(Counter, uint64)           {}
+func (nop) Observe(string, time.Duration) {}
+func (nop) SetSize(uint64)                {}
+func (nop) StartSpan(string) func()       { return endNop }
+
+func endNop() {}
+
+// Trace starts a span of the operation, and returns the function that ends it
+// and records its duration, which is intended to be deferred:
+//
+//	defer metrics.Trace(r, metrics.OpInsert)()
+func Trace(r Recorder, op string) func() {
+	start := time.Now()
+	end := r.StartSpan(op)
+	return func() {
+		end()
+		r.Observe(op, time.Since(start))
+	}
+}
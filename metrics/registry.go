@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// Registry is a Recorder that accumulates counters, the tree size and
+// per-operation latency histograms, and exports them in the Prometheus text
+// format. It can be served directly as an http.Handler. It does not trace, and
+// can be embedded in a Recorder that overrides StartSpan to do so.
+type Registry struct {
+	namespace string
+	buckets   []float64
+	counters  [numCounters]atomic.Uint64
+	size      atomic.Uint64
+
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, plus +Inf
+	count  uint64
+	sum    float64
+}
+
+var _ Recorder = (*Registry)(nil)
+var _ http.Handler = (*Registry)(nil)
+
+// NewRegistry returns a registry whose metric names are prefixed with the
+// given namespace, such as "imt".
+func NewRegistry(namespace string) *Registry {
+	return &Registry{
+		namespace:  namespace,
+		buckets:    DefaultBuckets,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (r *Registry) Add(c Counter, n uint64) {
+	r.counters[c].Add(n)
+}
+
+func (r *Registry) Observe(op string, d time.Duration) {
+	s := d.Seconds()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets)+1)}
+		r.histograms[op] = h
+	}
+	h.counts[sort.SearchFloat64s(r.buckets, s)]++
+	h.count++
+	h.sum += s
+}
+
+func (r *Registry) SetSize(size uint64) {
+	r.size.Store(size)
+}
+
+func (r *Registry) StartSpan(string) func() {
+	return endNop
+}
+
+// Count returns the current value of a counter.
+func (r *Registry) Count(c Counter) uint64 {
+	return r.counters[c].Load()
+}
+
+// Size returns the last recorded tree size.
+func (r *Registry) Size() uint64 {
+	return r.size.Load()
+}
+
+func (r *Registry) name(n string) string {
+	if r.namespace == "" {
+		return n
+	}
+	return r.namespace + "_" + n
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	help := map[Counter]string{
+		HashCalls: "Number of hash function calls.",
+		DBGets:    "Number of database point reads.",
+		DBSets:    "Number of database writes.",
+		DBSeeks:   "Number of database iterator seeks.",
+		DBDeletes: "Number of database deletes and range deletes.",
+	}
+	for c := Counter(0); c < numCounters; c++ {
+		name := r.name(c.String() + "_total")
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help[c], name, name, r.Count(c))
+	}
+	name := r.name("tree_size")
+	fmt.Fprintf(cw, "# HELP %s Number of leaves in the tree at the last commit.\n# TYPE %s gauge\n%s %d\n", name, name, name, r.Size())
+
+	r.mu.Lock()
+	ops := make([]string, 0, len(r.histograms))
+	for op := range r.histograms {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	name = r.name("operation_duration_seconds")
+	fmt.Fprintf(cw, "# HELP %s Latency of tree operations.\n# TYPE %s histogram\n", name, name)
+	for _, op := range ops {
+		h := r.histograms[op]
+		var cumulative uint64
+		for i, b := range r.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(cw, "%s_bucket{op=%q,le=%q} %d\n", name, op, formatFloat(b), cumulative)
+		}
+		fmt.Fprintf(cw, "%s_bucket{op=%q,le=\"+Inf\"} %d\n", name, op, h.count)
+		fmt.Fprintf(cw, "%s_sum{op=%q} %s\n", name, op, formatFloat(h.sum))
+		fmt.Fprintf(cw, "%s_count{op=%q} %d\n", name, op, h.count)
+	}
+	r.mu.Unlock()
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
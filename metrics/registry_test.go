@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("imt")
+	r.Add(HashCalls, 3)
+	r.Add(DBGets, 1)
+	r.SetSize(42)
+	r.Observe(OpInsert, 20*time.Microsecond)
+	r.Observe(OpInsert, 2*time.Second)
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"imt_hash_calls_total 3",
+		"imt_db_gets_total 1",
+		"imt_db_sets_total 0",
+		"imt_db_deletes_total 0",
+		"imt_tree_size 42",
+		`imt_operation_duration_seconds_bucket{op="insert",le="1e-05"} 0`,
+		`imt_operation_duration_seconds_bucket{op="insert",le="5e-05"} 1`,
+		`imt_operation_duration_seconds_bucket{op="insert",le="5"} 2`,
+		`imt_operation_duration_seconds_bucket{op="insert",le="+Inf"} 2`,
+		`imt_operation_duration_seconds_count{op="insert"} 2`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, b.String())
+		}
+	}
+}